@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+// HTTPRoute.Auth的取值
+const (
+	AuthNone     = 0 //不认证
+	AuthRequired = 1 //必须认证通过
+	AuthOptional = 2 //携带token时认证，未携带时放行
+)
+
+type Reason string
+
+const (
+	ReasonOK           Reason = "ok"
+	ReasonSkipped      Reason = "skipped"       //路由不需要认证
+	ReasonMissingToken Reason = "missing_token" //未携带token
+	ReasonInvalidToken Reason = "invalid_token" //token不存在或无法解析
+	ReasonExpired      Reason = "expired"       //token已过期
+	ReasonGuidMismatch Reason = "guid_mismatch" //请求中的guid与token绑定的guid不一致
+	ReasonServerError  Reason = "server_error"  //认证存储或认证服务不可用
+)
+
+// Status Reason对应的HTTP状态码
+func (r Reason) Status() int {
+	switch r {
+	case ReasonOK, ReasonSkipped:
+		return http.StatusOK
+	case ReasonGuidMismatch:
+		return http.StatusForbidden
+	case ReasonServerError:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// Principal 认证通过的用户
+type Principal struct {
+	UserId string
+	Guid   string
+	Tags   []string
+}
+
+// Credentials 从请求中提取的认证信息
+type Credentials struct {
+	Token string
+	Guid  string
+	Route *envoy.HTTPRoute
+}
+
+// Authenticator 认证失败时返回对应的Reason，error只用于记录存储或网络错误
+type Authenticator interface {
+	Authenticate(ctx context.Context, cred *Credentials) (*Principal, Reason, error)
+}
+
+// Result 一次认证的结果，Reason为ReasonOK时Principal不为空
+type Result struct {
+	Principal *Principal
+	Reason    Reason
+	Err       error
+}
+
+// Allowed 是否放行请求
+func (r *Result) Allowed() bool {
+	return r.Reason == ReasonOK || r.Reason == ReasonSkipped
+}
+
+// Selector 按路由的AuthServerVersion选择认证方式：LOCAL_REDIS_CHECK使用Local，AUTH_SERVER_CHECK使用Server
+type Selector struct {
+	Local  Authenticator
+	Server Authenticator
+}
+
+// For 路由对应的认证方式，未配置时返回nil
+func (s *Selector) For(route *envoy.HTTPRoute) Authenticator {
+	if route.AuthServerVersion == envoy.AUTH_SERVER_CHECK {
+		return s.Server
+	}
+	return s.Local
+}
+
+// ExtractCredentials token依次从Verify指定的header、query参数和Authorization: Bearer中读取，guid按GetGuIdKey读取
+func ExtractCredentials(r *http.Request, route *envoy.HTTPRoute) *Credentials {
+	cred := &Credentials{Route: route}
+
+	if route.Verify != "" {
+		cred.Token = r.Header.Get(route.Verify)
+		if cred.Token == "" {
+			cred.Token = util.GetUrlQueryStringByLastOne(route.Verify, r.URL.Query())
+		}
+	}
+	if cred.Token == "" {
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			cred.Token = strings.TrimPrefix(header, "Bearer ")
+		}
+	}
+
+	key := route.GetGuIdKey()
+	cred.Guid = r.Header.Get(key)
+	if cred.Guid == "" {
+		cred.Guid = util.GetUrlQueryStringByLastOne(key, r.URL.Query())
+	}
+
+	return cred
+}
+
+// Authenticate 按路由的Auth判断是否需要认证，并用对应的Authenticator认证请求
+func (s *Selector) Authenticate(r *http.Request, route *envoy.HTTPRoute) *Result {
+	if route == nil || route.Auth == AuthNone {
+		return &Result{Reason: ReasonSkipped}
+	}
+
+	cred := ExtractCredentials(r, route)
+	if cred.Token == "" {
+		if route.Auth == AuthOptional {
+			return &Result{Reason: ReasonSkipped}
+		}
+		return &Result{Reason: ReasonMissingToken}
+	}
+
+	authenticator := s.For(route)
+	if authenticator == nil {
+		return &Result{Reason: ReasonServerError}
+	}
+
+	principal, reason, err := authenticator.Authenticate(r.Context(), cred)
+	if reason == ReasonOK && principal == nil {
+		reason = ReasonInvalidToken
+	}
+
+	return &Result{Principal: principal, Reason: reason, Err: err}
+}
+
+// checkGuid token绑定了guid时，请求必须携带相同的guid
+func checkGuid(principal *Principal, cred *Credentials) Reason {
+	if principal.Guid != "" && principal.Guid != cred.Guid {
+		return ReasonGuidMismatch
+	}
+	return ReasonOK
+}
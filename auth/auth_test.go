@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+func TestSelector_Local(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetPrincipal("t1", &Principal{UserId: "u1", Guid: "g1"}, time.Minute)
+	store.SetPrincipal("t2", &Principal{UserId: "u2"}, time.Nanosecond) //过期后从存储中消失，与不存在的token一样处理
+	time.Sleep(time.Millisecond)
+
+	selector := &Selector{Local: &StoreAuthenticator{Store: store}}
+	route := &envoy.HTTPRoute{Prefix: "/user/", Auth: AuthRequired, Verify: "X-Token"}
+
+	cases := []struct {
+		url     string
+		headers map[string]string
+		reason  Reason
+	}{
+		{"/user/info?guid=g1", map[string]string{"X-Token": "t1"}, ReasonOK},
+		{"/user/info?X-Token=t1&guid=g1", nil, ReasonOK},
+		{"/user/info", map[string]string{"Authorization": "Bearer t1", "guid": "g1"}, ReasonOK},
+		{"/user/info?guid=g2", map[string]string{"X-Token": "t1"}, ReasonGuidMismatch},
+		{"/user/info", map[string]string{"X-Token": "t2"}, ReasonInvalidToken},
+		{"/user/info", nil, ReasonMissingToken},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "http://api.example.com"+c.url, nil)
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+
+		result := selector.Authenticate(r, route)
+		if result.Reason != c.reason {
+			t.Errorf("%s %v: expected %s, got %s", c.url, c.headers, c.reason, result.Reason)
+		}
+		if c.reason == ReasonOK && result.Principal.UserId != "u1" {
+			t.Errorf("unexpected principal %+v", result.Principal)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/user/info", nil)
+	if result := selector.Authenticate(r, &envoy.HTTPRoute{Auth: AuthOptional}); !result.Allowed() {
+		t.Fatal("optional auth without token should be allowed")
+	}
+	if result := selector.Authenticate(r, &envoy.HTTPRoute{}); result.Reason != ReasonSkipped {
+		t.Fatal("route without auth should be skipped")
+	}
+}
+
+func TestSelector_Server(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req serverRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		resp := serverResponse{Code: ServerCodeInvalidToken}
+		if req.Token == "valid" && req.Route == "/order/" {
+			resp = serverResponse{Code: ServerCodeOK, UserId: "u1", Tags: []string{"vip"}}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	selector := &Selector{
+		Local:  &StoreAuthenticator{Store: NewMemoryStore()},
+		Server: NewServerAuthenticator(server.URL, time.Second),
+	}
+	route := &envoy.HTTPRoute{Prefix: "/order/", Auth: AuthRequired, AuthServerVersion: envoy.AUTH_SERVER_CHECK}
+
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/order/1", nil)
+	r.Header.Set("Authorization", "Bearer valid")
+
+	result := selector.Authenticate(r, route)
+	if result.Reason != ReasonOK || result.Principal.UserId != "u1" || len(result.Principal.Tags) != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	r.Header.Set("Authorization", "Bearer other")
+	if result := selector.Authenticate(r, route); result.Reason != ReasonInvalidToken || result.Reason.Status() != http.StatusUnauthorized {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	server.Close()
+	r.Header.Set("Authorization", "Bearer valid")
+	if result := selector.Authenticate(r, route); result.Reason != ReasonServerError || result.Err == nil {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/plugin"
+)
+
+// PrincipalKey 认证通过后Principal在plugin.Context.Values中的key
+const PrincipalKey = "auth.principal"
+
+type authPlugin struct {
+	selector *Selector
+}
+
+func (p *authPlugin) OnAuth(ctx *plugin.Context) error {
+	result := p.selector.Authenticate(ctx.Request, ctx.Route)
+	if !result.Allowed() {
+		return plugin.Reject(result.Reason.Status(), string(result.Reason))
+	}
+
+	if result.Principal != nil && ctx.Values != nil {
+		ctx.Values[PrincipalKey] = result.Principal
+	}
+	return nil
+}
+
+// RegisterPlugin 以name注册认证插件，只对Auth不为AuthNone的路由生效
+func RegisterPlugin(name string, selector *Selector) {
+	plugin.Register(name, plugin.PhaseAuth, func(lds *envoy.LDS, route *envoy.HTTPRoute) (plugin.Plugin, error) {
+		if route.Auth == AuthNone {
+			return nil, nil
+		}
+		return &authPlugin{selector: selector}, nil
+	})
+}
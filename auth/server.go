@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mgcicd/cicd-core/util"
+)
+
+// 认证服务返回的Code
+const (
+	ServerCodeOK           = 0
+	ServerCodeInvalidToken = 1
+	ServerCodeExpired      = 2
+	ServerCodeGuidMismatch = 3
+)
+
+type serverRequest struct {
+	Token string `json:"token"`
+	Guid  string `json:"guid"`
+	Route string `json:"route"`
+}
+
+type serverResponse struct {
+	Code    int      `json:"code"`
+	Message string   `json:"message"`
+	UserId  string   `json:"userId"`
+	Guid    string   `json:"guid"`
+	Tags    []string `json:"tags"`
+}
+
+// ServerAuthenticator 对应AUTH_SERVER_CHECK，POST {token, guid, route}到认证服务
+type ServerAuthenticator struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewServerAuthenticator(url string, timeout time.Duration) *ServerAuthenticator {
+	return &ServerAuthenticator{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+func (a *ServerAuthenticator) Authenticate(ctx context.Context, cred *Credentials) (*Principal, Reason, error) {
+	payload := serverRequest{Token: cred.Token, Guid: cred.Guid}
+	if cred.Route != nil {
+		payload.Route = cred.Route.Prefix
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.URL, bytes.NewBufferString(util.StructToJson(payload)))
+	if err != nil {
+		return nil, ReasonServerError, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, ReasonServerError, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ReasonServerError, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, ReasonServerError, fmt.Errorf("auth server returned %d", resp.StatusCode)
+	}
+
+	result := &serverResponse{}
+	if err := util.ByteToStruct(body, result); err != nil {
+		return nil, ReasonServerError, err
+	}
+
+	switch result.Code {
+	case ServerCodeOK:
+	case ServerCodeExpired:
+		return nil, ReasonExpired, nil
+	case ServerCodeGuidMismatch:
+		return nil, ReasonGuidMismatch, nil
+	default:
+		return nil, ReasonInvalidToken, nil
+	}
+
+	principal := &Principal{UserId: result.UserId, Guid: result.Guid, Tags: result.Tags}
+	if reason := checkGuid(principal, cred); reason != ReasonOK {
+		return nil, reason, nil
+	}
+
+	return principal, ReasonOK, nil
+}
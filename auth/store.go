@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mgcicd/cicd-core/util"
+)
+
+// TokenStore token存储，线上为Redis，测试和本地开发使用MemoryStore
+// Get返回token对应的Principal JSON，token不存在时ok为false
+type TokenStore interface {
+	Get(ctx context.Context, key string) (value string, ok bool, err error)
+}
+
+const TokenKeyPrefix = "token:"
+
+// StoreAuthenticator 对应LOCAL_REDIS_CHECK，按"token:{token}"读取Principal
+type StoreAuthenticator struct {
+	Store TokenStore
+}
+
+func (a *StoreAuthenticator) Authenticate(ctx context.Context, cred *Credentials) (*Principal, Reason, error) {
+	value, ok, err := a.Store.Get(ctx, TokenKeyPrefix+cred.Token)
+	if err != nil {
+		return nil, ReasonServerError, err
+	}
+	if !ok {
+		return nil, ReasonInvalidToken, nil
+	}
+
+	principal := &Principal{}
+	if err := util.JsonToStruct(value, principal); err != nil || principal.UserId == "" {
+		return nil, ReasonInvalidToken, err
+	}
+
+	if reason := checkGuid(principal, cred); reason != ReasonOK {
+		return nil, reason, nil
+	}
+
+	return principal, ReasonOK, nil
+}
+
+type memoryEntry struct {
+	value    string
+	expireAt time.Time
+}
+
+// MemoryStore 进程内的TokenStore，代替Redis用于测试
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries map[string]*memoryEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+// Set ttl为0表示不过期
+func (s *MemoryStore) Set(key string, value string, ttl time.Duration) {
+	entry := &memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expireAt = time.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = entry
+}
+
+// SetPrincipal 按StoreAuthenticator使用的key写入token
+func (s *MemoryStore) SetPrincipal(token string, principal *Principal, ttl time.Duration) {
+	s.Set(TokenKeyPrefix+token, util.StructToJson(principal), ttl)
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[key]
+	if !ok || (!entry.expireAt.IsZero() && time.Now().After(entry.expireAt)) {
+		return "", false, nil
+	}
+
+	return entry.value, true, nil
+}
@@ -0,0 +1,211 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+type Resource int
+
+const (
+	Connection     Resource = 0 //连接数
+	PendingRequest Resource = 1 //等待中的请求数
+	Request        Resource = 2 //并发请求数
+	Retry          Resource = 3 //并发重试数
+	ConnectionPool Resource = 4 //连接池数
+)
+
+var resourceNames = []string{"connection", "pending_request", "request", "retry", "connection_pool"}
+
+func (r Resource) String() string {
+	if r < 0 || int(r) >= len(resourceNames) {
+		return "unknown"
+	}
+
+	return resourceNames[r]
+}
+
+// 与Envoy一致的默认阈值，CircuitBreaker中对应字段为0时使用
+const (
+	DefaultMaxConnections     = 1024
+	DefaultMaxPendingRequests = 1024
+	DefaultMaxRequests        = 1024
+	DefaultMaxRetries         = 3
+)
+
+var ErrOverflow = errors.New("circuit breaker overflow")
+
+type thresholds struct {
+	enabled        bool
+	limits         [5]uint32 //0表示不限制
+	trackRemaining bool
+}
+
+func newThresholds(enabled bool, cb *envoy.CircuitBreaker) *thresholds {
+	t := &thresholds{enabled: enabled}
+
+	t.limits[Connection] = DefaultMaxConnections
+	t.limits[PendingRequest] = DefaultMaxPendingRequests
+	t.limits[Request] = DefaultMaxRequests
+	t.limits[Retry] = DefaultMaxRetries
+
+	if cb == nil {
+		return t
+	}
+
+	t.trackRemaining = cb.TrackRemaining
+
+	if cb.MaxConnections > 0 {
+		t.limits[Connection] = cb.MaxConnections
+	}
+	if cb.MaxPendingRequests > 0 {
+		t.limits[PendingRequest] = cb.MaxPendingRequests
+	}
+	if cb.MaxRequests > 0 {
+		t.limits[Request] = cb.MaxRequests
+	}
+	if cb.MaxRetries > 0 {
+		t.limits[Retry] = cb.MaxRetries
+	}
+	t.limits[ConnectionPool] = cb.MaxConnectionPools
+
+	return t
+}
+
+// Breaker 单个集群单个优先级的熔断器，始终统计占用量，只有集群开启熔断时才拒绝
+type Breaker struct {
+	thresholds atomic.Value
+	active     [5]int64
+
+	mu       sync.Mutex
+	released chan struct{} //Request资源释放时关闭并替换，用于唤醒等待中的请求
+}
+
+func newBreaker(t *thresholds) *Breaker {
+	b := &Breaker{released: make(chan struct{})}
+	b.thresholds.Store(t)
+	return b
+}
+
+func (b *Breaker) current() *thresholds {
+	return b.thresholds.Load().(*thresholds)
+}
+
+// Acquire 占用一个资源，超过阈值时立即返回ErrOverflow；成功时必须调用release归还
+func (b *Breaker) Acquire(res Resource) (release func(), err error) {
+	t := b.current()
+
+	n := atomic.AddInt64(&b.active[res], 1)
+
+	if t.enabled && t.limits[res] > 0 && n > int64(t.limits[res]) {
+		atomic.AddInt64(&b.active[res], -1)
+		return nil, ErrOverflow
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&b.active[res], -1)
+			if res == Request && atomic.LoadInt64(&b.active[PendingRequest]) > 0 {
+				b.notify()
+			}
+		})
+	}, nil
+}
+
+func (b *Breaker) waitChan() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.released
+}
+
+func (b *Breaker) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// AcquireRequest 占用一个Request资源，已满时占用一个PendingRequest资源排队等待
+// 等待中的请求也超限时返回ErrOverflow，ctx结束时返回ctx.Err()
+func (b *Breaker) AcquireRequest(ctx context.Context) (release func(), err error) {
+	if release, err = b.Acquire(Request); err == nil {
+		return release, nil
+	}
+
+	releasePending, err := b.Acquire(PendingRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer releasePending()
+
+	for {
+		wait := b.waitChan()
+
+		if release, err = b.Acquire(Request); err == nil {
+			return release, nil
+		}
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// DialContext 每个连接在关闭前占用一个Connection资源，超限时不建立连接直接返回ErrOverflow
+func (b *Breaker) DialContext(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		release, err := b.Acquire(Connection)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			release()
+			return nil, err
+		}
+
+		return &releaseConn{Conn: conn, release: release}, nil
+	}
+}
+
+type releaseConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *releaseConn) Close() error {
+	defer c.release()
+	return c.Conn.Close()
+}
+
+// Remaining 资源剩余量，只有CircuitBreaker.TrackRemaining为true时ok才为true
+func (b *Breaker) Remaining(res Resource) (remaining uint32, ok bool) {
+	t := b.current()
+
+	if !t.trackRemaining || t.limits[res] == 0 {
+		return 0, false
+	}
+
+	active := atomic.LoadInt64(&b.active[res])
+	if active >= int64(t.limits[res]) {
+		return 0, true
+	}
+
+	return t.limits[res] - uint32(active), true
+}
+
+// Active 当前占用量
+func (b *Breaker) Active(res Resource) int64 {
+	return atomic.LoadInt64(&b.active[res])
+}
@@ -0,0 +1,165 @@
+package breaker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func TestRegistry_Acquire(t *testing.T) {
+	m := &common.Manager{}
+	m.SetCache("/cds/order", util.StructToJson(&envoy.EDS{
+		Name:                 "order",
+		EnableCircuitbreaker: int(util.True),
+		CircuitBreaker:       []*envoy.CircuitBreaker{{MaxRequests: 2, TrackRemaining: true}},
+	}))
+
+	r := NewRegistry(m)
+	defer r.Close()
+
+	b := r.Get("order")
+
+	release1, err := b.Acquire(Request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Acquire(Request); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Acquire(Request); err != ErrOverflow {
+		t.Fatalf("expected overflow, got %v", err)
+	}
+	if remaining, ok := b.Remaining(Request); !ok || remaining != 0 {
+		t.Fatalf("unexpected remaining %d %v", remaining, ok)
+	}
+
+	release1()
+	release1()
+	if remaining, _ := b.Remaining(Request); remaining != 1 {
+		t.Fatalf("release should be idempotent, remaining %d", remaining)
+	}
+
+	if remaining, ok := b.Remaining(Retry); !ok || remaining != DefaultMaxRetries {
+		t.Fatalf("unexpected retry remaining %d %v", remaining, ok)
+	}
+}
+
+func TestRegistry_Reload(t *testing.T) {
+	m := &common.Manager{}
+	m.SetCache("/cds/order", util.StructToJson(&envoy.EDS{
+		Name:           "order",
+		CircuitBreaker: []*envoy.CircuitBreaker{{MaxConnections: 1}},
+	}))
+
+	r := NewRegistry(m)
+	defer r.Close()
+
+	b := r.Get("order")
+	for i := 0; i < 3; i++ {
+		if _, err := b.Acquire(Connection); err != nil {
+			t.Fatal("breaker is disabled and should not reject")
+		}
+	}
+
+	m.SetCache("/cds/order", util.StructToJson(&envoy.EDS{
+		Name:                 "order",
+		EnableCircuitbreaker: int(util.True),
+		CircuitBreaker:       []*envoy.CircuitBreaker{{MaxConnections: 1}},
+	}))
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := b.Acquire(Connection); err == ErrOverflow {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("breaker limits were not reloaded")
+}
+
+func TestRegistry_RoundTripper(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+
+	m := &common.Manager{}
+	m.SetCache("/cds/order", util.StructToJson(&envoy.EDS{
+		Name:                 "order",
+		EnableCircuitbreaker: int(util.True),
+		CircuitBreaker:       []*envoy.CircuitBreaker{{MaxConnections: 1, MaxRequests: 1, MaxPendingRequests: 1}},
+	}))
+
+	r := NewRegistry(m)
+	defer r.Close()
+
+	client := &http.Client{Transport: r.RoundTripper("order", nil)}
+	b := r.Get("order")
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			errs <- err
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for b.Active(Request) != 1 || b.Active(PendingRequest) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected one active and one pending request, got %d %d", b.Active(Request), b.Active(PendingRequest))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := client.Get(server.URL); err == nil || !strings.Contains(err.Error(), ErrOverflow.Error()) {
+		t.Fatalf("pending overflow expected, got %v", err)
+	}
+
+	if b.Active(Connection) != 1 {
+		t.Fatalf("expected one connection, got %d", b.Active(Connection))
+	}
+
+	close(block)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBreaker_DialContext(t *testing.T) {
+	b := newBreaker(newThresholds(true, &envoy.CircuitBreaker{MaxConnections: 1}))
+
+	dial := b.DialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+
+	conn, err := dial(context.Background(), "tcp", "order:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dial(context.Background(), "tcp", "order:80"); err != ErrOverflow {
+		t.Fatalf("expected overflow, got %v", err)
+	}
+
+	conn.Close()
+	if _, err := dial(context.Background(), "tcp", "order:80"); err != nil {
+		t.Fatalf("connection should be released on close, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package breaker
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+type breakerKey struct {
+	cluster  string
+	priority int32
+}
+
+// Registry 按集群维护熔断器，CDS节点变更时只替换阈值，已占用的资源计数保持不变
+type Registry struct {
+	manager *common.Manager
+
+	mu       sync.Mutex
+	clusters map[string]*envoy.EDS
+	breakers map[breakerKey]*Breaker
+	stop     chan struct{}
+}
+
+func NewRegistry(m *common.Manager) *Registry {
+	r := &Registry{
+		manager:  m,
+		breakers: make(map[breakerKey]*Breaker),
+		stop:     make(chan struct{}),
+	}
+
+	cds := m.Subscribe("/cds/")
+
+	r.Reload()
+
+	go r.watch(cds)
+
+	return r
+}
+
+func (r *Registry) watch(cds <-chan common.ChangedEvent) {
+	for {
+		select {
+		case <-cds:
+			r.Reload()
+		case <-r.stop:
+			r.manager.Unsubscribe(cds)
+			return
+		}
+	}
+}
+
+func (r *Registry) Close() {
+	close(r.stop)
+}
+
+// Reload 重新读取所有集群的熔断配置
+func (r *Registry) Reload() {
+	clusters := make(map[string]*envoy.EDS)
+	for name, eds := range r.manager.GetAllCds() {
+		clusters[name] = eds
+		if eds.Name != "" {
+			clusters[eds.Name] = eds
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clusters = clusters
+	for key, b := range r.breakers {
+		b.thresholds.Store(r.thresholdsOf(key))
+	}
+}
+
+func (r *Registry) thresholdsOf(key breakerKey) *thresholds {
+	eds := r.clusters[key.cluster]
+	if eds == nil {
+		return newThresholds(false, nil)
+	}
+
+	enabled := eds.EnableCircuitbreaker == int(util.True)
+
+	for _, cb := range eds.CircuitBreaker {
+		if cb != nil && cb.Priority == key.priority {
+			return newThresholds(enabled, cb)
+		}
+	}
+
+	return newThresholds(enabled, nil)
+}
+
+// Get 默认优先级(0)的熔断器
+func (r *Registry) Get(cluster string) *Breaker {
+	return r.GetWithPriority(cluster, 0)
+}
+
+func (r *Registry) GetWithPriority(cluster string, priority int32) *Breaker {
+	key := breakerKey{cluster: cluster, priority: priority}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b := r.breakers[key]
+	if b == nil {
+		b = newBreaker(r.thresholdsOf(key))
+		r.breakers[key] = b
+	}
+
+	return b
+}
+
+// RoundTripper 每个请求在响应body关闭前占用一个Request资源，并发请求已满时作为等待中的请求排队，等待也超限时直接返回ErrOverflow不发出请求
+// next为空或为*http.Transport时，复制一份并通过DialContext限制连接数；其它RoundTripper无法统计连接，只限制请求数
+func (r *Registry) RoundTripper(cluster string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	b := r.Get(cluster)
+
+	if transport, ok := next.(*http.Transport); ok {
+		transport = transport.Clone()
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		transport.DialContext = b.DialContext(dial)
+		next = transport
+	}
+
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		release, err := b.AcquireRequest(req.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			release()
+			return nil, err
+		}
+
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+		return resp, nil
+	})
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,187 @@
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+type IssueType string
+
+const (
+	DuplicateRoute IssueType = "duplicate_route" //同一域名下相同匹配方式、相同Prefix的路由出现多次
+	ShadowedRoute  IssueType = "shadowed_route"  //Regex路由能匹配的路径全部被某个Prefix路由先匹配
+	MissingCluster IssueType = "missing_cluster" //路由指向的集群在/cds中不存在
+	DomainConflict IssueType = "domain_conflict" //同一域名被多个监听器声明
+)
+
+// Issue Sources为涉及的位置，格式为"LDS节点名#监听器序号"
+type Issue struct {
+	Type    IssueType
+	Domain  string
+	Route   string
+	Cluster string
+	Sources []string
+	Message string
+}
+
+type routeRef struct {
+	source    string
+	matchType envoy.RouteMatchType
+	route     *envoy.HTTPRoute
+}
+
+// AnalyzeManager 分析Manager缓存中的全部/lds、/cds节点
+func AnalyzeManager(m *common.Manager) []Issue {
+	return Analyze(m.GetAllLds(), m.GetAllCds())
+}
+
+// Analyze lds、cds的key为节点名，匹配优先级与proxy包一致：Path完全匹配、最长Prefix、Regex
+func Analyze(lds map[string]*envoy.LDS, cds map[string]*envoy.EDS) []Issue {
+	clusters := make(map[string]bool)
+	for name, eds := range cds {
+		clusters[name] = true
+		if eds.Name != "" {
+			clusters[eds.Name] = true
+		}
+	}
+
+	nodes := make([]string, 0, len(lds))
+	for node := range lds {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	domainListeners := make(map[string][]string)
+	domainRoutes := make(map[string][]*routeRef)
+
+	var issues []Issue
+
+	for _, node := range nodes {
+		doc := lds[node]
+
+		for i, listener := range doc.Listeners {
+			if listener == nil {
+				continue
+			}
+			source := node + "#" + strconv.Itoa(i)
+
+			for _, domain := range listener.Domains {
+				domain = strings.ToLower(domain)
+				domainListeners[domain] = append(domainListeners[domain], source)
+
+				for _, route := range listener.Routes {
+					if route != nil {
+						domainRoutes[domain] = append(domainRoutes[domain], &routeRef{source: source, matchType: doc.RouteMatchType, route: route})
+					}
+				}
+			}
+
+			for _, route := range listener.Routes {
+				if route != nil && !clusters[route.ClusterName] {
+					issues = append(issues, Issue{
+						Type:    MissingCluster,
+						Route:   route.Prefix,
+						Cluster: route.ClusterName,
+						Sources: []string{source},
+						Message: fmt.Sprintf("route %s points to cluster %q which does not exist in /cds", route.Prefix, route.ClusterName),
+					})
+				}
+			}
+		}
+	}
+
+	domains := make([]string, 0, len(domainListeners))
+	for domain := range domainListeners {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		if sources := domainListeners[domain]; len(sources) > 1 {
+			issues = append(issues, Issue{
+				Type:    DomainConflict,
+				Domain:  domain,
+				Sources: sources,
+				Message: fmt.Sprintf("domain %s is claimed by %d listeners: %s", domain, len(sources), strings.Join(sources, ", ")),
+			})
+		}
+
+		issues = append(issues, duplicates(domain, domainRoutes[domain])...)
+		issues = append(issues, shadows(domain, domainRoutes[domain])...)
+	}
+
+	return issues
+}
+
+func duplicates(domain string, routes []*routeRef) []Issue {
+	type key struct {
+		matchType envoy.RouteMatchType
+		prefix    string
+	}
+
+	groups := make(map[key][]string)
+	var order []key
+
+	for _, ref := range routes {
+		k := key{matchType: ref.matchType, prefix: ref.route.Prefix}
+		if groups[k] == nil {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], ref.source)
+	}
+
+	var issues []Issue
+	for _, k := range order {
+		if sources := groups[k]; len(sources) > 1 {
+			issues = append(issues, Issue{
+				Type:    DuplicateRoute,
+				Domain:  domain,
+				Route:   k.prefix,
+				Sources: sources,
+				Message: fmt.Sprintf("route %s on %s is declared %d times: %s", k.prefix, domain, len(sources), strings.Join(sources, ", ")),
+			})
+		}
+	}
+
+	return issues
+}
+
+// shadows Prefix路由优先于Regex路由匹配，Regex的字面量前缀以某个Prefix开头时该Regex路由永远不会命中
+func shadows(domain string, routes []*routeRef) []Issue {
+	var issues []Issue
+
+	for _, regexRef := range routes {
+		if regexRef.matchType != envoy.Regex {
+			continue
+		}
+
+		regex, err := regexp.Compile("^(?:" + regexRef.route.Prefix + ")$")
+		if err != nil {
+			continue
+		}
+		literal, _ := regex.LiteralPrefix()
+
+		for _, prefixRef := range routes {
+			if prefixRef.matchType != envoy.Prefix || !strings.HasPrefix(literal, prefixRef.route.Prefix) {
+				continue
+			}
+
+			issues = append(issues, Issue{
+				Type:    ShadowedRoute,
+				Domain:  domain,
+				Route:   regexRef.route.Prefix,
+				Sources: []string{regexRef.source, prefixRef.source},
+				Message: fmt.Sprintf("regex route %s on %s is shadowed by prefix route %s (%s)", regexRef.route.Prefix, domain, prefixRef.route.Prefix, prefixRef.source),
+			})
+			break
+		}
+	}
+
+	return issues
+}
@@ -0,0 +1,74 @@
+package analyzer
+
+import (
+	"testing"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+func TestAnalyze(t *testing.T) {
+	lds := map[string]*envoy.LDS{
+		"order": {
+			RouteMatchType: envoy.Prefix,
+			Listeners: []*envoy.Listener{{
+				Domains: []string{"api.example.com"},
+				Routes:  []*envoy.HTTPRoute{{Prefix: "/order", ClusterName: "order"}},
+			}},
+		},
+		"order-detail": {
+			RouteMatchType: envoy.Regex,
+			Listeners: []*envoy.Listener{{
+				Domains: []string{"API.example.com"},
+				Routes:  []*envoy.HTTPRoute{{Prefix: "/order/[0-9]+", ClusterName: "order-detail"}},
+			}},
+		},
+		"order-copy": {
+			RouteMatchType: envoy.Prefix,
+			Listeners: []*envoy.Listener{{
+				Domains: []string{"api.example.com"},
+				Routes:  []*envoy.HTTPRoute{{Prefix: "/order", ClusterName: "order"}},
+			}},
+		},
+		"pay": {
+			RouteMatchType: envoy.Prefix,
+			Listeners: []*envoy.Listener{{
+				Domains: []string{"pay.example.com"},
+				Routes:  []*envoy.HTTPRoute{{Prefix: "/", ClusterName: "pay"}},
+			}},
+		},
+	}
+	cds := map[string]*envoy.EDS{
+		"order":        {Name: "order"},
+		"order-detail": {Name: "order-detail"},
+	}
+
+	counts := make(map[IssueType]int)
+	for _, issue := range Analyze(lds, cds) {
+		counts[issue.Type]++
+
+		switch issue.Type {
+		case DomainConflict:
+			if issue.Domain != "api.example.com" || len(issue.Sources) != 3 {
+				t.Errorf("unexpected issue %+v", issue)
+			}
+		case DuplicateRoute:
+			if issue.Route != "/order" || len(issue.Sources) != 2 {
+				t.Errorf("unexpected issue %+v", issue)
+			}
+		case ShadowedRoute:
+			if issue.Route != "/order/[0-9]+" {
+				t.Errorf("unexpected issue %+v", issue)
+			}
+		case MissingCluster:
+			if issue.Cluster != "pay" {
+				t.Errorf("unexpected issue %+v", issue)
+			}
+		}
+	}
+
+	for _, issueType := range []IssueType{DomainConflict, DuplicateRoute, ShadowedRoute, MissingCluster} {
+		if counts[issueType] != 1 {
+			t.Errorf("expected 1 %s issue, got %d", issueType, counts[issueType])
+		}
+	}
+}
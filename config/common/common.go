@@ -18,6 +18,9 @@ import (
 
 var Paths = "config,lds,cds,connection,service"
 
+// InternalUsersPath 内部用户配置节点，内容为envoy.InternalUsers的JSON
+const InternalUsersPath = "/config/internalusers"
+
 type ChangedEvent struct {
 	Path string
 }
@@ -27,6 +30,14 @@ type Manager struct {
 	CdsChangedEvent           chan ChangedEvent
 	LdsChangedEvent           chan ChangedEvent
 	InternalUsersChangedEvent chan ChangedEvent
+
+	subMutex    sync.Mutex
+	subscribers []*subscriber
+}
+
+type subscriber struct {
+	prefix string
+	ch     chan ChangedEvent
 }
 
 var manager *Manager
@@ -53,6 +64,7 @@ func NewManager() *Manager {
 			case zk.EventNodeDataChanged:
 				{
 					manager.update(event.Path)
+					manager.notify(event.Path)
 					log.Printf("EventNodeDataChanged: %s\n", event.Path)
 					if strings.HasPrefix(event.Path, "/cds/") {
 						go func() {
@@ -80,11 +92,18 @@ func NewManager() *Manager {
 							}
 						}()
 					}
+					if event.Path == InternalUsersPath {
+						manager.fireInternalUsersChanged(event.Path)
+					}
 				}
 			case zk.EventNodeDeleted:
 				{
 					manager.delete(event.Path)
+					manager.notify(event.Path)
 					log.Printf("EventNodeDeleted: %s\n", event.Path)
+					if event.Path == InternalUsersPath {
+						manager.fireInternalUsersChanged(event.Path)
+					}
 				}
 			case zk.EventNodeChildrenChanged:
 				{
@@ -122,6 +141,25 @@ func NewManager() *Manager {
 	return manager
 }
 
+// fireInternalUsersChanged 与CdsChangedEvent/LdsChangedEvent相同，没有消费者时事件由goroutine挂起等待
+func (m *Manager) fireInternalUsersChanged(path string) {
+	if m.InternalUsersChangedEvent == nil {
+		return
+	}
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Println(err)
+			}
+		}()
+
+		m.InternalUsersChangedEvent <- ChangedEvent{
+			Path: path,
+		}
+	}()
+}
+
 var mutex sync.Mutex
 
 var cbs = make([]func(zk *zk2.ZK) error, 0)
@@ -383,6 +421,45 @@ func (m *Manager) GetAll(configPath string) map[string]string {
 	return mapping
 }
 
+// GetAllLds 返回缓存中所有/lds节点，key为节点名，返回的对象为共享缓存不可修改
+func (m *Manager) GetAllLds() map[string]*envoy.LDS {
+	mapping := make(map[string]*envoy.LDS)
+
+	m.configMap.Range(func(key, value interface{}) bool {
+		if lds, ok := value.(*envoy.LDS); ok && strings.HasPrefix(key.(string), "/lds/") {
+			mapping[strings.TrimPrefix(key.(string), "/lds/")] = lds
+		}
+		return true
+	})
+
+	return mapping
+}
+
+// GetAllCds 返回缓存中所有/cds节点，key为节点名，返回的对象为共享缓存不可修改
+func (m *Manager) GetAllCds() map[string]*envoy.EDS {
+	mapping := make(map[string]*envoy.EDS)
+
+	m.configMap.Range(func(key, value interface{}) bool {
+		if eds, ok := value.(*envoy.EDS); ok && strings.HasPrefix(key.(string), "/cds/") {
+			mapping[strings.TrimPrefix(key.(string), "/cds/")] = eds
+		}
+		return true
+	})
+
+	return mapping
+}
+
+// GetInternalUsers 返回缓存中的内部用户配置，节点不存在时返回空配置，返回的对象为共享缓存不可修改
+func (m *Manager) GetInternalUsers() *envoy.InternalUsers {
+	if value, ok := m.configMap.Load(InternalUsersPath); ok {
+		if users, ok := value.(*envoy.InternalUsers); ok {
+			return users
+		}
+	}
+
+	return &envoy.InternalUsers{}
+}
+
 func (m *Manager) Dispose() {
 	zoo.Conn.Close()
 }
@@ -394,7 +471,12 @@ func (m *Manager) set(k string, v string) {
 		value = &envoy.LDS{}
 		_ = util.ByteToStruct([]byte(v), value)
 	} else if strings.HasPrefix(k, "/cds") {
-		value = &envoy.EDS{}
+		eds := &envoy.EDS{}
+		_ = util.ByteToStruct([]byte(v), eds)
+		eds.NodeName = k[strings.LastIndex(k, "/")+1:]
+		value = eds
+	} else if k == InternalUsersPath {
+		value = &envoy.InternalUsers{}
 		_ = util.ByteToStruct([]byte(v), value)
 	} else {
 		value = v
@@ -405,4 +487,61 @@ func (m *Manager) set(k string, v string) {
 
 func (m *Manager) SetCache(k string, v string) {
 	m.set(k, v)
+	m.notify(k)
+	if k == InternalUsersPath {
+		m.fireInternalUsersChanged(k)
+	}
+}
+
+func (m *Manager) DeleteCache(k string) {
+	m.delete(k)
+	m.notify(k)
+	if k == InternalUsersPath {
+		m.fireInternalUsersChanged(k)
+	}
+}
+
+// Subscribe 订阅以prefix开头的节点变更(修改、删除)事件
+// 与CdsChangedEvent/LdsChangedEvent不同，每个订阅者都会收到事件，互不抢占
+// 订阅者处理不及时时事件会被合并丢弃，收到事件后应重新读取全量配置
+func (m *Manager) Subscribe(prefix string) <-chan ChangedEvent {
+	m.subMutex.Lock()
+	defer m.subMutex.Unlock()
+
+	sub := &subscriber{
+		prefix: prefix,
+		ch:     make(chan ChangedEvent, 16),
+	}
+	m.subscribers = append(m.subscribers, sub)
+
+	return sub.ch
+}
+
+// Unsubscribe 取消Subscribe返回的订阅
+func (m *Manager) Unsubscribe(ch <-chan ChangedEvent) {
+	m.subMutex.Lock()
+	defer m.subMutex.Unlock()
+
+	for i, sub := range m.subscribers {
+		if sub.ch == ch {
+			m.subscribers = append(m.subscribers[:i], m.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *Manager) notify(path string) {
+	m.subMutex.Lock()
+	defer m.subMutex.Unlock()
+
+	for _, sub := range m.subscribers {
+		if !strings.HasPrefix(path, sub.prefix) {
+			continue
+		}
+
+		select {
+		case sub.ch <- ChangedEvent{Path: path}:
+		default:
+		}
+	}
 }
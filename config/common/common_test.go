@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManager_InternalUsersChanged(t *testing.T) {
+	m := &Manager{InternalUsersChangedEvent: make(chan ChangedEvent)}
+
+	m.SetCache(InternalUsersPath, `{"Users":[]}`)
+	select {
+	case event := <-m.InternalUsersChangedEvent:
+		if event.Path != InternalUsersPath {
+			t.Fatalf("path = %q", event.Path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event after SetCache")
+	}
+
+	m.DeleteCache(InternalUsersPath)
+	select {
+	case <-m.InternalUsersChangedEvent:
+	case <-time.After(time.Second):
+		t.Fatal("no event after DeleteCache")
+	}
+
+	m.SetCache("/config/other", "{}")
+	select {
+	case event := <-m.InternalUsersChangedEvent:
+		t.Fatalf("unexpected event %v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
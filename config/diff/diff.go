@@ -0,0 +1,296 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+type ChangeType string
+
+const (
+	Added    ChangeType = "added"
+	Removed  ChangeType = "removed"
+	Modified ChangeType = "modified"
+)
+
+// FieldChange 某个字段修改前后的值
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// Change 一个对象的变更，Kind为lds、plugin、listener、route、cluster、port、endpoint、version
+type Change struct {
+	Type   ChangeType    `json:"type"`
+	Kind   string        `json:"kind"`
+	Key    string        `json:"key"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// Report 一个LDS或EDS节点的全部变更
+type Report struct {
+	Kind    string   `json:"kind"`
+	Name    string   `json:"name"`
+	Changes []Change `json:"changes"`
+}
+
+func (r *Report) Empty() bool {
+	return len(r.Changes) == 0
+}
+
+func (r *Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// String 供命令行和审核页面展示的文本，+新增 -删除 ~修改
+func (r *Report) String() string {
+	var builder strings.Builder
+
+	builder.WriteString(fmt.Sprintf("%s %s: %d change(s)\n", r.Kind, r.Name, len(r.Changes)))
+
+	for _, change := range r.Changes {
+		mark := "~"
+		switch change.Type {
+		case Added:
+			mark = "+"
+		case Removed:
+			mark = "-"
+		}
+
+		builder.WriteString(fmt.Sprintf("  %s %s %s\n", mark, change.Kind, change.Key))
+
+		for _, field := range change.Fields {
+			builder.WriteString(fmt.Sprintf("      %s: %s -> %s\n", field.Field, formatValue(field.Old), formatValue(field.New)))
+		}
+	}
+
+	return builder.String()
+}
+
+func formatValue(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return "<nil>"
+	}
+
+	switch reflect.Indirect(rv).Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Map:
+		return util.StructToJson(v)
+	case reflect.String:
+		return strconv.Quote(fmt.Sprint(v))
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Lds old为空表示新建，new为空表示删除
+func Lds(old *envoy.LDS, new *envoy.LDS) *Report {
+	report := &Report{Kind: "lds"}
+	if new != nil {
+		report.Name = new.Name
+	} else if old != nil {
+		report.Name = old.Name
+	}
+
+	if old == nil {
+		old = &envoy.LDS{}
+	}
+	if new == nil {
+		new = &envoy.LDS{}
+	}
+
+	if fields := fieldChanges(old, new, "Plugins", "Listeners"); len(fields) > 0 {
+		report.Changes = append(report.Changes, Change{Type: Modified, Kind: "lds", Key: report.Name, Fields: fields})
+	}
+
+	report.Changes = append(report.Changes, diffKeyed("plugin", pluginsByName(old.Plugins), pluginsByName(new.Plugins))...)
+
+	oldListeners, oldRoutes := listenersByDomains(old.Listeners)
+	newListeners, newRoutes := listenersByDomains(new.Listeners)
+
+	report.Changes = append(report.Changes, diffKeyed("listener", oldListeners, newListeners, "Routes", "Domains")...)
+	report.Changes = append(report.Changes, diffKeyed("route", oldRoutes, newRoutes)...)
+
+	return report
+}
+
+// Eds old为空表示新建，new为空表示删除
+func Eds(old *envoy.EDS, new *envoy.EDS) *Report {
+	report := &Report{Kind: "eds"}
+	if new != nil {
+		report.Name = new.Name
+	} else if old != nil {
+		report.Name = old.Name
+	}
+
+	if old == nil {
+		old = &envoy.EDS{}
+	}
+	if new == nil {
+		new = &envoy.EDS{}
+	}
+
+	if fields := fieldChanges(old, new, "Endpoints", "Ports", "EDSVersions", "NodeName"); len(fields) > 0 {
+		report.Changes = append(report.Changes, Change{Type: Modified, Kind: "cluster", Key: report.Name, Fields: fields})
+	}
+
+	report.Changes = append(report.Changes, diffKeyed("port", portsByKey(old.Ports), portsByKey(new.Ports))...)
+	report.Changes = append(report.Changes, diffKeyed("endpoint", endpointsByKey(old.Endpoints), endpointsByKey(new.Endpoints))...)
+	report.Changes = append(report.Changes, diffKeyed("version", versionsByKey(old.EDSVersions), versionsByKey(new.EDSVersions))...)
+
+	return report
+}
+
+func pluginsByName(plugins []*envoy.Plugins) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, p := range plugins {
+		if p != nil {
+			result[p.PluginName] = p
+		}
+	}
+	return result
+}
+
+// listenersByDomains 监听器以排序后的域名为key，路由以"域名 Prefix"为key
+func listenersByDomains(listeners []*envoy.Listener) (map[string]interface{}, map[string]interface{}) {
+	byDomains := make(map[string]interface{})
+	routes := make(map[string]interface{})
+
+	for _, l := range listeners {
+		if l == nil {
+			continue
+		}
+
+		domains := append([]string(nil), l.Domains...)
+		sort.Strings(domains)
+		key := strings.Join(domains, ",")
+		byDomains[key] = l
+
+		for _, r := range l.Routes {
+			if r != nil {
+				routes[key+" "+r.Prefix] = r
+			}
+		}
+	}
+
+	return byDomains, routes
+}
+
+func portsByKey(ports []*envoy.Ports) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, p := range ports {
+		if p != nil {
+			result[p.Name+"/"+strconv.Itoa(p.Port)] = p
+		}
+	}
+	return result
+}
+
+// endpointsByKey 有Name时以Name为key，否则以ip:port为key
+func endpointsByKey(endpoints []*envoy.Endpoint) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, ep := range endpoints {
+		if ep == nil {
+			continue
+		}
+		key := ep.Name
+		if key == "" {
+			key = ep.Ip + ":" + strconv.Itoa(ep.Port)
+		}
+		result[key] = ep
+	}
+	return result
+}
+
+func versionsByKey(versions []envoy.EDS_Version) map[string]interface{} {
+	result := make(map[string]interface{})
+	for i := range versions {
+		result[versions[i].Version] = &versions[i]
+	}
+	return result
+}
+
+func diffKeyed(kind string, old map[string]interface{}, new map[string]interface{}, skip ...string) []Change {
+	keys := make([]string, 0, len(old)+len(new))
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range new {
+		if _, ok := old[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []Change
+	for _, key := range keys {
+		o, inOld := old[key]
+		n, inNew := new[key]
+
+		switch {
+		case !inOld:
+			changes = append(changes, Change{Type: Added, Kind: kind, Key: key})
+		case !inNew:
+			changes = append(changes, Change{Type: Removed, Kind: kind, Key: key})
+		default:
+			if fields := fieldChanges(o, n, skip...); len(fields) > 0 {
+				changes = append(changes, Change{Type: Modified, Kind: kind, Key: key, Fields: fields})
+			}
+		}
+	}
+
+	return changes
+}
+
+// fieldChanges 逐个比较结构体的导出字段，nil与空切片视为相同
+func fieldChanges(old interface{}, new interface{}, skip ...string) []FieldChange {
+	ov := reflect.Indirect(reflect.ValueOf(old))
+	nv := reflect.Indirect(reflect.ValueOf(new))
+	t := ov.Type()
+
+	var changes []FieldChange
+
+fields:
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		for _, name := range skip {
+			if name == field.Name {
+				continue fields
+			}
+		}
+
+		a := ov.Field(i)
+		b := nv.Field(i)
+
+		if isEmpty(a) && isEmpty(b) {
+			continue
+		}
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			changes = append(changes, FieldChange{Field: field.Name, Old: a.Interface(), New: b.Interface()})
+		}
+	}
+
+	return changes
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,93 @@
+package diff
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+func TestLds(t *testing.T) {
+	old := &envoy.LDS{
+		Name: "order",
+		Listeners: []*envoy.Listener{{
+			Domains: []string{"b.example.com", "a.example.com"},
+			Routes: []*envoy.HTTPRoute{
+				{Prefix: "/order/", ClusterName: "order", TimeOut: 5},
+				{Prefix: "/pay/", ClusterName: "pay"},
+			},
+		}},
+	}
+	new := &envoy.LDS{
+		Name: "order",
+		Listeners: []*envoy.Listener{{
+			Domains:   []string{"a.example.com", "b.example.com"},
+			EnableTLS: true,
+			Routes: []*envoy.HTTPRoute{
+				{Prefix: "/order/", ClusterName: "order", TimeOut: 10},
+				{Prefix: "/refund/", ClusterName: "refund"},
+			},
+		}},
+	}
+
+	report := Lds(old, new)
+
+	expected := []string{
+		"modified listener a.example.com,b.example.com",
+		"modified route a.example.com,b.example.com /order/",
+		"removed route a.example.com,b.example.com /pay/",
+		"added route a.example.com,b.example.com /refund/",
+	}
+	if len(report.Changes) != len(expected) {
+		t.Fatalf("unexpected changes\n%s", report)
+	}
+	for i, change := range report.Changes {
+		if string(change.Type)+" "+change.Kind+" "+change.Key != expected[i] {
+			t.Fatalf("unexpected change %d\n%s", i, report)
+		}
+	}
+
+	if !strings.Contains(report.String(), "TimeOut: 5 -> 10") {
+		t.Fatalf("unexpected text\n%s", report)
+	}
+
+	data, err := report.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded.Changes) != len(expected) {
+		t.Fatalf("unexpected json %s", data)
+	}
+
+	if !Lds(old, old).Empty() {
+		t.Fatal("same lds should have no changes")
+	}
+}
+
+func TestEds(t *testing.T) {
+	old := &envoy.EDS{
+		Name:        "order",
+		Endpoints:   []*envoy.Endpoint{{Name: "order-a", Ip: "10.0.0.1"}, {Ip: "10.0.0.2", Port: 80}},
+		EDSVersions: []envoy.EDS_Version{{Version: "prod", FlowWeight: 90}, {Version: "canary", FlowWeight: 10}},
+	}
+	new := &envoy.EDS{
+		Name:                 "order",
+		Endpoints:            []*envoy.Endpoint{{Name: "order-a", Ip: "10.0.0.3"}},
+		EDSVersions:          []envoy.EDS_Version{{Version: "prod", FlowWeight: 50}, {Version: "canary", FlowWeight: 50}},
+		EnableCircuitbreaker: 1,
+	}
+
+	report := Eds(old, new)
+
+	kinds := make([]string, 0)
+	for _, change := range report.Changes {
+		kinds = append(kinds, string(change.Type)+" "+change.Kind+" "+change.Key)
+	}
+
+	expected := "modified cluster order|removed endpoint 10.0.0.2:80|modified endpoint order-a|modified version canary|modified version prod"
+	if strings.Join(kinds, "|") != expected {
+		t.Fatalf("unexpected changes\n%s", report)
+	}
+}
@@ -0,0 +1,195 @@
+package envoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 以下枚举在JSON中仍输出为数字，读取时同时接受数字和名称(不区分大小写)，String输出名称
+// 不输出名称的原因：读取同一zk节点的其他服务和旧版本按数字解析这些字段，输出名称会导致它们解析失败；
+// 且CDS/LDS版本号按JSON计算，改为名称会使所有已有节点的版本号变化。因此zk中的JSON暂不自描述，
+// 所有读取方都能接受名称后再输出名称
+// 未定义的数字原样保留
+
+type enumName struct {
+	value int
+	name  string
+}
+
+func enumString(names []enumName, value int) string {
+	for _, n := range names {
+		if n.value == value {
+			return n.name
+		}
+	}
+	return strconv.Itoa(value)
+}
+
+func parseEnum(typeName string, names []enumName, text string) (int, error) {
+	s := strings.TrimSpace(text)
+
+	for _, n := range names {
+		if strings.EqualFold(n.name, s) {
+			return n.value, nil
+		}
+	}
+
+	if value, err := strconv.Atoi(s); err == nil {
+		return value, nil
+	}
+
+	return 0, fmt.Errorf("envoy: invalid %s %q", typeName, text)
+}
+
+// parseEnumJSON JSON中的值可能是字符串(名称或数字)或数字
+func parseEnumJSON(typeName string, names []enumName, data []byte) (int, bool, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return 0, false, nil
+	}
+
+	text := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return 0, false, err
+		}
+	}
+
+	value, err := parseEnum(typeName, names, text)
+	return value, err == nil, err
+}
+
+// k8sKindNames 同一取值的第一个名称用于输出，其余为兼容的别名
+var k8sKindNames = []enumName{
+	{int(K8sKind_Image), "image"},
+	{int(K8sKind_Expanded), "expanded"},
+	{int(K8sKind_Expanded), "deployment"},
+	{int(K8sKind_Expanded), "extenDeploy"},
+	{int(K8sKind_TimeJob), "timeJob"},
+	{int(K8sKind_TimeJob), "cronJob"},
+	{int(K8sKind_JobWithEnv), "jobWithEnv"},
+	{int(K8sKind_JobWithEnv), "envJob"},
+	{int(K8sKind_Fronted), "frontend"},
+	{int(K8sKind_Fronted), "frontDeploy"},
+	{int(K8sKind_Job), "job"},
+	{int(K8sKind_Gateway), "gateway"},
+}
+
+func (k K8sKind) String() string {
+	return enumString(k8sKindNames, int(k))
+}
+
+func (k *K8sKind) UnmarshalText(text []byte) error {
+	value, err := parseEnum("K8sKind", k8sKindNames, string(text))
+	if err == nil {
+		*k = K8sKind(value)
+	}
+	return err
+}
+
+func (k *K8sKind) UnmarshalJSON(data []byte) error {
+	value, ok, err := parseEnumJSON("K8sKind", k8sKindNames, data)
+	if ok {
+		*k = K8sKind(value)
+	}
+	return err
+}
+
+// ParseK8sKind 接受名称、别名或数字
+func ParseK8sKind(s string) (K8sKind, error) {
+	var k K8sKind
+	err := k.UnmarshalText([]byte(s))
+	return k, err
+}
+
+// KindId 转换为EDS中使用的EDS_K8SKindId，K8sKind_Image对应Image(0)
+func (k K8sKind) KindId() EDS_K8SKindId {
+	if k == K8sKind_Image {
+		return Image
+	}
+	return EDS_K8SKindId(k)
+}
+
+// Kind 转换为K8sKind，Nil与Image同为0，统一转换为K8sKind_Image
+func (id EDS_K8SKindId) Kind() K8sKind {
+	if id == Image {
+		return K8sKind_Image
+	}
+	return K8sKind(id)
+}
+
+func (id EDS_K8SKindId) String() string {
+	return id.Kind().String()
+}
+
+func (id *EDS_K8SKindId) UnmarshalText(text []byte) error {
+	var k K8sKind
+	if err := k.UnmarshalText(text); err != nil {
+		return err
+	}
+	*id = k.KindId()
+	return nil
+}
+
+func (id *EDS_K8SKindId) UnmarshalJSON(data []byte) error {
+	var k K8sKind
+	if err := k.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*id = k.KindId()
+	return nil
+}
+
+var versionPolicyNames = []enumName{
+	{int(A), "A"},
+	{int(B), "B"},
+	{int(C), "C"},
+}
+
+func (p EDS_Version_Policy) String() string {
+	return enumString(versionPolicyNames, int(p))
+}
+
+func (p *EDS_Version_Policy) UnmarshalText(text []byte) error {
+	value, err := parseEnum("EDS_Version_Policy", versionPolicyNames, string(text))
+	if err == nil {
+		*p = EDS_Version_Policy(value)
+	}
+	return err
+}
+
+func (p *EDS_Version_Policy) UnmarshalJSON(data []byte) error {
+	value, ok, err := parseEnumJSON("EDS_Version_Policy", versionPolicyNames, data)
+	if ok {
+		*p = EDS_Version_Policy(value)
+	}
+	return err
+}
+
+var flowControlPolicyNames = []enumName{
+	{int(Policy_Gray), "gray"},
+	{int(Policy_Weight), "weight"},
+}
+
+func (p Flow_Control_Policy) String() string {
+	return enumString(flowControlPolicyNames, int(p))
+}
+
+func (p *Flow_Control_Policy) UnmarshalText(text []byte) error {
+	value, err := parseEnum("Flow_Control_Policy", flowControlPolicyNames, string(text))
+	if err == nil {
+		*p = Flow_Control_Policy(value)
+	}
+	return err
+}
+
+func (p *Flow_Control_Policy) UnmarshalJSON(data []byte) error {
+	value, ok, err := parseEnumJSON("Flow_Control_Policy", flowControlPolicyNames, data)
+	if ok {
+		*p = Flow_Control_Policy(value)
+	}
+	return err
+}
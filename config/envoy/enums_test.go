@@ -0,0 +1,80 @@
+package envoy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func TestEnums_LegacyJson(t *testing.T) {
+	legacy := `{"Name":"order","K8SKindId":4,"EDSVersions":[{"Version":"canary","SelectPolicy":1,"Policys":[0,2]}]}`
+
+	for name, unmarshal := range map[string]func(string, interface{}) error{
+		"jsoniter": util.JsonToStruct,
+		"encoding/json": func(s string, v interface{}) error {
+			return json.Unmarshal([]byte(s), v)
+		},
+	} {
+		eds := &EDS{}
+		if err := unmarshal(legacy, eds); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if eds.K8SKindId != FrontDeploy || eds.EDSVersions[0].SelectPolicy != Policy_Weight || eds.EDSVersions[0].Policys[1] != C {
+			t.Fatalf("%s: unexpected eds %+v", name, eds)
+		}
+	}
+}
+
+func TestEnums_Names(t *testing.T) {
+	eds := &EDS{K8SKindId: Gateway, EDSVersions: []EDS_Version{{SelectPolicy: Policy_Gray, Policys: []EDS_Version_Policy{B}}}}
+
+	data := util.StructToJson(eds)
+	for _, expected := range []string{`"K8SKindId":6`, `"SelectPolicy":0`, `"Policys":[1]`} {
+		if !strings.Contains(data, expected) {
+			t.Fatalf("expected %s in %s", expected, data)
+		}
+	}
+
+	decoded := &EDS{}
+	named := `{"K8SKindId":"gateway","EDSVersions":[{"SelectPolicy":"weight","Policys":["b"]}]}`
+	if err := util.JsonToStruct(named, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.K8SKindId != Gateway || decoded.EDSVersions[0].SelectPolicy != Policy_Weight || decoded.EDSVersions[0].Policys[0] != B {
+		t.Fatalf("unexpected decoded %+v", decoded)
+	}
+	if s := decoded.K8SKindId.String(); s != "gateway" {
+		t.Fatalf("unexpected name %s", s)
+	}
+
+	if err := util.JsonToStruct(`{"K8SKindId":"unknown"}`, &EDS{}); err == nil {
+		t.Fatal("expected error for unknown kind name")
+	}
+}
+
+func TestK8sKind_Convert(t *testing.T) {
+	cases := map[string]K8sKind{
+		"image":      K8sKind_Image,
+		"-1":         K8sKind_Image,
+		"CronJob":    K8sKind_TimeJob,
+		"deployment": K8sKind_Expanded,
+		"6":          K8sKind_Gateway,
+	}
+
+	for s, expected := range cases {
+		if k, err := ParseK8sKind(s); err != nil || k != expected {
+			t.Errorf("%s: expected %s, got %s %v", s, expected, k, err)
+		}
+	}
+
+	// 旧的无类型常量仍可以作为int使用
+	var legacy int = ExtenDeploy + B + Policy_Weight
+	if legacy != 3 || Image != 0 || EDS_K8SKindId(Image).Kind() != K8sKind_Image || K8sKind_Image.KindId() != Image || K8sKind_JobWithEnv.KindId() != EnvJob {
+		t.Fatal("unexpected conversion")
+	}
+	if s := K8sKind(42).String(); s != "42" {
+		t.Fatalf("unknown values should keep their number, got %s", s)
+	}
+}
@@ -2,11 +2,14 @@ package envoy
 
 import (
 	"bytes"
-	"crypto/md5"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mgcicd/cicd-core/util"
 )
@@ -14,11 +17,69 @@ import (
 type ListenerProtocol string
 
 const (
-	LpTcp   ListenerProtocol = "TCP"
-	LpHttp  ListenerProtocol = "HTTP"
-	LpHttp2 ListenerProtocol = "HTTP2"
+	LpTcp       ListenerProtocol = "TCP"
+	LpHttp      ListenerProtocol = "HTTP"
+	LpHttp2     ListenerProtocol = "HTTP2"
+	LpGrpc      ListenerProtocol = "GRPC"
+	LpHttps     ListenerProtocol = "HTTPS"
+	LpTls       ListenerProtocol = "TLS"
+	LpWebSocket ListenerProtocol = "WEBSOCKET"
 )
 
+// ParseListenerProtocol 不区分大小写，支持Kubernetes appProtocol的写法(如kubernetes.io/h2c)，无法识别时返回false
+func ParseListenerProtocol(s string) (ListenerProtocol, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if protocol, ok := parseListenerProtocol(s); ok {
+		return protocol, true
+	}
+
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		return parseListenerProtocol(s[i+1:])
+	}
+
+	return "", false
+}
+
+func parseListenerProtocol(s string) (ListenerProtocol, bool) {
+	switch s {
+	case "tcp":
+		return LpTcp, true
+	case "http", "http1", "http/1.1":
+		return LpHttp, true
+	case "http2", "h2c", "h2":
+		return LpHttp2, true
+	case "grpc", "grpc-web":
+		return LpGrpc, true
+	case "https":
+		return LpHttps, true
+	case "tls":
+		return LpTls, true
+	case "ws", "websocket", "websockets":
+		return LpWebSocket, true
+	}
+
+	return "", false
+}
+
+// IsHTTP 是否为七层HTTP协议(包括HTTP2、gRPC、HTTPS、WebSocket)
+func (lp ListenerProtocol) IsHTTP() bool {
+	switch lp {
+	case LpHttp, LpHttp2, LpGrpc, LpHttps, LpWebSocket:
+		return true
+	}
+	return false
+}
+
+// IsHTTP2 上游需要使用HTTP2连接
+func (lp ListenerProtocol) IsHTTP2() bool {
+	return lp == LpHttp2 || lp == LpGrpc
+}
+
+// IsTLS 上游需要使用TLS连接
+func (lp ListenerProtocol) IsTLS() bool {
+	return lp == LpHttps || lp == LpTls
+}
+
 type RouteMatchType int
 
 const (
@@ -36,6 +97,20 @@ const (
 	Unit_Day    UnitType = 3 //天
 )
 
+// Duration 限速单位对应的时间窗口
+func (u UnitType) Duration() time.Duration {
+	switch u {
+	case Unit_Minute:
+		return time.Minute
+	case Unit_Hour:
+		return time.Hour
+	case Unit_Day:
+		return 24 * time.Hour
+	default:
+		return time.Second
+	}
+}
+
 type LimitType int
 
 const (
@@ -95,12 +170,85 @@ func (l *LDS) WithPlugin(pluginName string) bool {
 type Plugins struct {
 	PluginName      string
 	FunctionalTypes int32
+	Phases          int32 `json:",omitempty"` //插件执行的阶段(plugin.Phase按位组合)，0表示注册时声明的所有阶段，与FunctionalTypes无关
 }
 
 type Listener struct {
 	Routes    []*HTTPRoute
 	Domains   []string
 	EnableTLS bool
+	TLS       *TLSSettings //EnableTLS为true时生效
+}
+
+type ClientAuthType int
+
+const (
+	ClientAuth_None    ClientAuthType = 0 //不验证客户端证书
+	ClientAuth_Request ClientAuthType = 1 //客户端提供证书时验证
+	ClientAuth_Require ClientAuthType = 2 //客户端必须提供证书并通过验证
+)
+
+type TLSSettings struct {
+	Certificates []*TLSCertificate
+	MinVersion   string //1.0、1.1、1.2、1.3，为空时为1.2
+	ClientAuth   ClientAuthType
+	ClientCARef  string //验证客户端证书的CA，ClientAuth不为ClientAuth_None时必填
+}
+
+// TLSCertificate CertRef、KeyRef为zk节点路径(可带zk:前缀)或file:开头的文件路径
+// Domains为该证书对应的SNI，为空时对应Listener.Domains
+type TLSCertificate struct {
+	Domains []string
+	CertRef string
+	KeyRef  string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// GetMinVersion 对应tls.Config.MinVersion
+func (t *TLSSettings) GetMinVersion() (uint16, error) {
+	if t == nil || t.MinVersion == "" {
+		return tls.VersionTLS12, nil
+	}
+
+	version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(t.MinVersion), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported tls version %s", t.MinVersion)
+	}
+	return version, nil
+}
+
+func (t *TLSSettings) Validate() error {
+	if t == nil || len(t.Certificates) == 0 {
+		return errors.New("tls enabled without certificates")
+	}
+
+	if _, err := t.GetMinVersion(); err != nil {
+		return err
+	}
+
+	for i, cert := range t.Certificates {
+		if cert == nil || cert.CertRef == "" || cert.KeyRef == "" {
+			return fmt.Errorf("certificate %d: cert and key are required", i)
+		}
+	}
+
+	switch t.ClientAuth {
+	case ClientAuth_None:
+	case ClientAuth_Request, ClientAuth_Require:
+		if t.ClientCARef == "" {
+			return errors.New("client ca is required when client auth is enabled")
+		}
+	default:
+		return fmt.Errorf("unsupported client auth type %d", t.ClientAuth)
+	}
+
+	return nil
 }
 
 var Cities = []string{"sz", "sh", "wx", "cz", "ks"}
@@ -131,6 +279,184 @@ type HTTPRoute struct {
 	IfNeedEncryptContent  bool       //OpenApi是否需要加密发送数据
 	AuthServerVersion     int        //是否采用v2版本的认证 // 0 代表redis认证 1 代表 v2版本走auth server认证
 	IsCityTagPrefixEnable CityPrefix //是否带城市路由 /sz /ks /sh /wx  0 表示带城市表示 1 表示不带城市表示
+	Mirror                *MirrorPolicy
+	TimeOutPolicy         *TimeOutPolicy
+	Retry                 *RetryPolicy
+	ParamRules            []*ParamRule //VerifyParam为true时校验的请求参数
+}
+
+const DefaultMirrorHeader = "X-Shadow-Request"
+
+// MirrorPolicy 路由的流量镜像，LDS.EnableMirror为true时生效
+// 请求被异步复制一份发送到影子集群，影子集群的响应被丢弃
+type MirrorPolicy struct {
+	ClusterName string  //影子集群
+	Percentage  float64 //镜像比例 0-100
+	Header      string  //影子请求上标记的header名，为空时为X-Shadow-Request
+}
+
+func (mp *MirrorPolicy) GetHeader() string {
+	if mp.Header == "" {
+		return DefaultMirrorHeader
+	}
+	return mp.Header
+}
+
+// GetMirror 路由生效的镜像配置，未开启EnableMirror或未配置时返回nil
+func (l *LDS) GetMirror(route *HTTPRoute) *MirrorPolicy {
+	if !l.EnableMirror || route == nil || route.Mirror == nil || route.Mirror.ClusterName == "" || route.Mirror.Percentage <= 0 {
+		return nil
+	}
+	return route.Mirror
+}
+
+// GetTimeOut 路由超时时间，优先取TimeOutPolicy.TimeOutMs，其次TimeOut(秒)，0表示不限制
+func (r *HTTPRoute) GetTimeOut() time.Duration {
+	if r == nil {
+		return 0
+	}
+
+	if r.TimeOutPolicy != nil && r.TimeOutPolicy.TimeOutMs > 0 {
+		return time.Duration(r.TimeOutPolicy.TimeOutMs) * time.Millisecond
+	}
+
+	if r.TimeOut <= 0 {
+		return 0
+	}
+
+	return time.Duration(r.TimeOut) * time.Second
+}
+
+// GetPerTryTimeOut 每次尝试的超时，0表示只受GetTimeOut限制
+func (r *HTTPRoute) GetPerTryTimeOut() time.Duration {
+	if r == nil || r.TimeOutPolicy == nil || r.TimeOutPolicy.PerTryTimeOutMs <= 0 {
+		return 0
+	}
+
+	return time.Duration(r.TimeOutPolicy.PerTryTimeOutMs) * time.Millisecond
+}
+
+// GetIdleTimeOut TimeOutPolicy.IdleTimeOut(秒)，0表示不限制
+func (r *HTTPRoute) GetIdleTimeOut() time.Duration {
+	if r == nil || r.TimeOutPolicy == nil || r.TimeOutPolicy.IdleTimeOut <= 0 {
+		return 0
+	}
+
+	return time.Duration(r.TimeOutPolicy.IdleTimeOut) * time.Second
+}
+
+// GetGuIdKey 请求中携带guid的header/query参数名，GuId为空时默认为guid
+func (r *HTTPRoute) GetGuIdKey() string {
+	if r == nil || r.GuId == "" {
+		return "guid"
+	}
+
+	return r.GuId
+}
+
+type ParamIn string
+
+const (
+	ParamInQuery  ParamIn = "query"
+	ParamInHeader ParamIn = "header"
+)
+
+type ParamType string
+
+const (
+	ParamString ParamType = "string"
+	ParamInt    ParamType = "int"
+	ParamFloat  ParamType = "float"
+	ParamBool   ParamType = "bool"
+)
+
+// ParamRule 请求参数的校验规则，参数出现多次时取最后一个值，与GetUrlQueryStringByLastOne一致
+// 参数为空时只校验Required，其余规则只对非空参数生效
+type ParamRule struct {
+	Name      string
+	In        ParamIn //为空时为query
+	Required  bool
+	Type      ParamType //为空时为string
+	Pattern   string    //正则表达式，需要整体匹配时自行加上^$
+	MinLength int
+	MaxLength int //0表示不限制
+	Enum      []string
+}
+
+func (pr *ParamRule) GetIn() ParamIn {
+	if pr.In == "" {
+		return ParamInQuery
+	}
+	return pr.In
+}
+
+func (pr *ParamRule) GetType() ParamType {
+	if pr.Type == "" {
+		return ParamString
+	}
+	return pr.Type
+}
+
+// InternalUsers 内部用户配置，命中开启了BindInternalUsers的路由时请求被路由到BindInternalUserTag版本
+type InternalUsers struct {
+	Users []*InternalUser
+}
+
+type InternalUser struct {
+	UserId string
+	Guid   string
+	Name   string
+	Tags   []string //允许访问的版本标签，为空表示不限制
+	Status util.YesOrNo
+}
+
+// HasTag 用户是否允许访问tag版本
+func (u *InternalUser) HasTag(tag string) bool {
+	if len(u.Tags) == 0 {
+		return true
+	}
+
+	for _, t := range u.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Find 按UserId或Guid查找启用的内部用户，都为空或未找到时返回nil
+func (iu *InternalUsers) Find(userId string, guid string) *InternalUser {
+	if iu == nil || (userId == "" && guid == "") {
+		return nil
+	}
+
+	for _, u := range iu.Users {
+		if u == nil || u.Status == util.No {
+			continue
+		}
+
+		if (userId != "" && u.UserId == userId) || (guid != "" && u.Guid == guid) {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// RouteTag 路由开启了BindInternalUsers且用户是允许访问BindInternalUserTag的内部用户时，返回该标签和true
+// guid可能来自请求头或参数，结果只用于选择版本，不是访问控制的边界
+func (iu *InternalUsers) RouteTag(route *HTTPRoute, userId string, guid string) (string, bool) {
+	if route == nil || !route.BindInternalUsers || route.BindInternalUserTag == "" {
+		return "", false
+	}
+
+	u := iu.Find(userId, guid)
+	if u == nil || !u.HasTag(route.BindInternalUserTag) {
+		return "", false
+	}
+
+	return route.BindInternalUserTag, true
 }
 
 type ABTag struct {
@@ -166,6 +492,8 @@ type Cluster struct {
 type EDS struct {
 	Endpoints        []*Endpoint
 	Name             string
+	NodeName         string `json:"-"` //所在/cds节点的名称，由Manager加载时设置，不写入zk
+	Namespace        string //由k8s同步时为Service所在的命名空间，为空表示不由同步器维护
 	Version          string
 	ClusterIp        string
 	Ports            []*Ports
@@ -198,18 +526,82 @@ type EDS struct {
 	GrayStrategy int
 }
 
+// GetProtocol 集群的协议，取第一个端口的协议，没有端口时取第一个endpoint的协议
+func (eds *EDS) GetProtocol() ListenerProtocol {
+	for _, p := range eds.Ports {
+		if p != nil {
+			return p.GetProtocol()
+		}
+	}
+
+	for _, ep := range eds.Endpoints {
+		if ep != nil {
+			return ep.GetProtocol()
+		}
+	}
+
+	return LpTcp
+}
+
 type HealthCheck struct {
 
 	/**
-	每次健康检查扫描的间隔时间
+	每次健康检查扫描的间隔时间(秒)
 	*/
 	Interval int64
 	/**
+	扫描间隔(毫秒)，大于0时优先于Interval
+	*/
+	IntervalMs int64
+	/**
+	单次检查的超时时间(毫秒)
+	*/
+	TimeoutMs int64
+	/**
+	连续成功多少次后标记为健康
+	*/
+	HealthyThreshold uint32
+	/**
+	连续失败多少次后标记为不健康
+	*/
+	UnhealthyThreshold uint32
+	/**
 	http健康检查
 	*/
 	HttpHealthCheck HttpHealthCheck
 }
 
+func (hc HealthCheck) GetInterval() time.Duration {
+	if hc.IntervalMs > 0 {
+		return time.Duration(hc.IntervalMs) * time.Millisecond
+	}
+	if hc.Interval > 0 {
+		return time.Duration(hc.Interval) * time.Second
+	}
+	return 10 * time.Second
+}
+
+func (hc HealthCheck) GetTimeout() time.Duration {
+	if hc.TimeoutMs <= 0 {
+		return time.Second
+	}
+	return time.Duration(hc.TimeoutMs) * time.Millisecond
+}
+
+func (hc HealthCheck) GetHealthyThreshold() uint32 {
+	if hc.HealthyThreshold == 0 {
+		return 2
+	}
+	return hc.HealthyThreshold
+}
+
+func (hc HealthCheck) GetUnhealthyThreshold() uint32 {
+	if hc.UnhealthyThreshold == 0 {
+		return 3
+	}
+	return hc.UnhealthyThreshold
+}
+
 type HttpHealthCheck struct {
 	/**
 	路径
@@ -217,6 +609,7 @@ type HttpHealthCheck struct {
 	Path string
 }
 
+// EDS_K8SKindId 除Image外与K8sKind取值一致，可用Kind()相互转换
 type EDS_K8SKindId int
 
 const (
@@ -227,6 +620,7 @@ const (
 	FrontDeploy               = 4
 	Job                       = 5
 	Image                     = 0
+	Gateway                   = 6
 )
 
 const (
@@ -236,38 +630,35 @@ const (
 )
 
 type Ports struct {
-	Name       string
-	Protocol   string
-	Port       int
-	NodePort   int
-	TargetPort int
+	Name        string
+	Protocol    string
+	AppProtocol string //对应Kubernetes ServicePort.appProtocol
+	Port        int
+	NodePort    int
+	TargetPort  int
 }
 
+// GetProtocol 优先级：AppProtocol > Protocol(TCP、UDP等传输层协议除外) > 端口名约定(如order-http) > TCP
 func (ports *Ports) GetProtocol() ListenerProtocol {
+	if protocol, ok := ParseListenerProtocol(ports.AppProtocol); ok {
+		return protocol
+	}
+
+	if protocol, ok := ParseListenerProtocol(ports.Protocol); ok && protocol != LpTcp {
+		return protocol
+	}
+
 	protocols := strings.Split(ports.Name, "-")
 
 	if len(protocols) < 2 {
 		return LpTcp
 	}
 
-	var protocol ListenerProtocol
-
-	switch protocols[1] {
-	case "tcp":
-		protocol = LpTcp
-		break
-	case "http":
-		protocol = LpHttp
-		break
-	case "http2":
-		protocol = LpHttp2
-		break
-	default:
-		protocol = LpTcp
-		break
+	if protocol, ok := ParseListenerProtocol(protocols[1]); ok {
+		return protocol
 	}
 
-	return protocol
+	return LpTcp
 }
 
 type EDS_Version_Policy int
@@ -313,18 +704,163 @@ type CircuitBreaker struct {
 */
 type OutlierDetection struct {
 	Consecutive_5Xx          uint32
-	Interval                 uint32
-	BaseEjectionTime         uint32
+	Interval                 uint32 //驱逐次数回落的间隔(秒)，与HealthCheck.Interval单位一致
+	BaseEjectionTime         uint32 //基础驱逐时长(秒)
 	MaxEjectionPercent       uint32
-	EnforcingConsecutive_5Xx uint32
+	EnforcingConsecutive_5Xx uint32 //触发驱逐的概率(百分比)，0时为100
+}
+
+// 以下各字段为0时取Envoy的默认值
+
+func (od OutlierDetection) GetConsecutive5xx() uint32 {
+	if od.Consecutive_5Xx == 0 {
+		return 5
+	}
+	return od.Consecutive_5Xx
+}
+
+func (od OutlierDetection) GetInterval() time.Duration {
+	if od.Interval == 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(od.Interval) * time.Second
+}
+
+func (od OutlierDetection) GetBaseEjectionTime() time.Duration {
+	if od.BaseEjectionTime == 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(od.BaseEjectionTime) * time.Second
 }
 
+func (od OutlierDetection) GetMaxEjectionPercent() uint32 {
+	if od.MaxEjectionPercent == 0 {
+		return 10
+	}
+	return od.MaxEjectionPercent
+}
+
+// GetEnforcingConsecutive5xx 已有节点中该字段总是写为0，因此0按默认值100处理，不使用Envoy中0表示从不驱逐的含义
+func (od OutlierDetection) GetEnforcingConsecutive5xx() uint32 {
+	if od.EnforcingConsecutive_5Xx == 0 || od.EnforcingConsecutive_5Xx > 100 {
+		return 100
+	}
+	return od.EnforcingConsecutive_5Xx
+}
+
+// TimeOutPolicy 路由的超时设置，0表示不限制
 type TimeOutPolicy struct {
-	IdleTimeOut int
+	IdleTimeOut     int //连接上没有请求/响应数据的最长时间(秒)，与HTTPRoute.TimeOut单位一致
+	TimeOutMs       int //整个请求(包括所有重试)的超时(毫秒)，不为0时代替HTTPRoute.TimeOut
+	PerTryTimeOutMs int //每次尝试的超时(毫秒)
+}
+
+type RetryOn string
+
+// 重试条件，与Envoy x-envoy-retry-on的取值一致
+const (
+	RetryOn5xx            RetryOn = "5xx"             //上游返回5xx或连接失败
+	RetryOnGatewayError   RetryOn = "gateway-error"   //上游返回502、503、504或连接失败
+	RetryOnConnectFailure RetryOn = "connect-failure" //连接上游失败或超时
+)
+
+// RetryPolicy 路由的重试策略，集群的并发重试数同时受CircuitBreaker.MaxRetries限制
+type RetryPolicy struct {
+	RetryOn    []RetryOn
+	NumRetries uint32 //最大重试次数，0表示不重试
+
+	/**
+	重试间隔为[0, min(BaseInterval*2^n, MaxInterval))内的随机值(毫秒)，BaseInterval为0时为25，MaxInterval为0时为BaseInterval的10倍
+	*/
+	BaseInterval int
+	MaxInterval  int
+
+	/**
+	重试预算：并发重试数不超过并发请求数的BudgetPercent%，且至少允许MinRetryConcurrency个，BudgetPercent为0时不限制
+	*/
+	BudgetPercent       float64
+	MinRetryConcurrency uint32
+}
+
+func (rp *RetryPolicy) GetNumRetries() uint32 {
+	if rp == nil {
+		return 0
+	}
+	return rp.NumRetries
+}
+
+func (rp *RetryPolicy) GetBaseInterval() time.Duration {
+	if rp.BaseInterval <= 0 {
+		return 25 * time.Millisecond
+	}
+	return time.Duration(rp.BaseInterval) * time.Millisecond
+}
+
+func (rp *RetryPolicy) GetMaxInterval() time.Duration {
+	if rp.MaxInterval <= 0 {
+		return 10 * rp.GetBaseInterval()
+	}
+	return time.Duration(rp.MaxInterval) * time.Millisecond
+}
+
+// GetMinRetryConcurrency 未配置时为3，与Envoy一致
+func (rp *RetryPolicy) GetMinRetryConcurrency() uint32 {
+	if rp.MinRetryConcurrency == 0 {
+		return 3
+	}
+	return rp.MinRetryConcurrency
+}
+
+// RetryOnString 以逗号连接的重试条件，未配置时为5xx
+func (rp *RetryPolicy) RetryOnString() string {
+	if len(rp.RetryOn) == 0 {
+		return string(RetryOn5xx)
+	}
+
+	conditions := make([]string, 0, len(rp.RetryOn))
+	for _, on := range rp.RetryOn {
+		conditions = append(conditions, string(on))
+	}
+	return strings.Join(conditions, ",")
+}
+
+// ShouldRetry 按重试条件判断一次尝试的结果是否需要重试，err不为空表示未收到响应
+func (rp *RetryPolicy) ShouldRetry(statusCode int, err error) bool {
+	conditions := rp.RetryOn
+	if len(conditions) == 0 {
+		conditions = []RetryOn{RetryOn5xx}
+	}
+
+	for _, on := range conditions {
+		switch on {
+		case RetryOn5xx:
+			if err != nil || statusCode >= 500 {
+				return true
+			}
+		case RetryOnGatewayError:
+			if err != nil || statusCode == 502 || statusCode == 503 || statusCode == 504 {
+				return true
+			}
+		case RetryOnConnectFailure:
+			if err != nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ClusterKey 集群的唯一标识，取/cds节点名，未经Manager加载时取Name
+func (eds *EDS) ClusterKey() string {
+	if eds.NodeName != "" {
+		return eds.NodeName
+	}
+	return eds.Name
 }
 
 func (eds *EDS) GetEndpoint() (endpoint *Endpoint, error error) {
-	var ep, err = doroundrobin(eds)
+	var ep, err = doroundrobin(eds, eds.Endpoints)
 
 	if err != nil {
 		return nil, err
@@ -333,6 +869,19 @@ func (eds *EDS) GetEndpoint() (endpoint *Endpoint, error error) {
 	return ep, nil
 }
 
+// GetVersionEndpoint 只在Version为version的endpoint中轮询
+func (eds *EDS) GetVersionEndpoint(version string) (*Endpoint, error) {
+	versioned := make([]*Endpoint, 0)
+
+	for _, ep := range eds.Endpoints {
+		if ep != nil && ep.Version == version {
+			versioned = append(versioned, ep)
+		}
+	}
+
+	return doroundrobin(eds, versioned)
+}
+
 func (eds *EDS) GetVersions() []string {
 	var eps []string = make([]string, 0)
 	if eds.Endpoints == nil {
@@ -379,57 +928,61 @@ func (eds *EDS) GetPolicys() []EDS_Version_Policy {
 	return evps
 }
 
-func (eds *EDS) GetCdsVersion() string {
-	if eds == nil || eds.Endpoints == nil || len(eds.Endpoints) == 0 {
-		return ""
-	}
+// EndpointFilter 负载均衡时过滤暂不可用的endpoint，比如被异常点检测驱逐的实例
+type EndpointFilter interface {
+	Available(eds *EDS, ep *Endpoint) bool
+}
 
-	// 获取endpoint名字数组
-	var podNames []string
-	for _, ep := range eds.Endpoints {
-		podNames = append(podNames, ep.Name)
-	}
+var filterMutex sync.RWMutex
+var endpointFilters []EndpointFilter
 
-	// 获取EDSVersions版本名字以及禁用启用状态
-	var edsVersions []string
-	var enable string
-	for _, edsVersion := range eds.EDSVersions {
-		if edsVersion.Enable == util.No {
-			enable = "false"
-		} else {
-			enable = "true"
-		}
-		edsVersions = append(edsVersions, edsVersion.Version+"-"+enable)
-	}
+func RegisterEndpointFilter(filter EndpointFilter) {
+	filterMutex.Lock()
+	defer filterMutex.Unlock()
 
-	// 获取endpoint IP数组
-	var endPointsVersion []string
-	for _, endpoint := range eds.Endpoints {
-		endPointsVersion = append(endPointsVersion, endpoint.Ip)
-	}
+	endpointFilters = append(endpointFilters, filter)
+}
 
-	r := strings.Join(podNames, "*") + strings.Join(edsVersions, "*") + strings.Join(endPointsVersion, "*") + eds.ClusterIp
+func endpointAvailable(eds *EDS, ep *Endpoint) bool {
+	filterMutex.RLock()
+	defer filterMutex.RUnlock()
 
-	byte16 := md5.Sum([]byte(r))
+	for _, filter := range endpointFilters {
+		if !filter.Available(eds, ep) {
+			return false
+		}
+	}
 
-	return fmt.Sprintf("%x", byte16)
+	return true
 }
 
-var curIndex = 0
+// roundRobinIndex 每个集群一个轮询计数，key为ClusterKey，值为*uint64
+var roundRobinIndex sync.Map
 
-func doroundrobin(des *EDS) (inst *Endpoint, err error) {
+func doroundrobin(des *EDS, endpoints []*Endpoint) (inst *Endpoint, err error) {
 
-	if len(des.Endpoints) == 0 {
+	if len(endpoints) == 0 {
 		err = errors.New("no endpoints")
 		return
 	}
-	lens := len(des.Endpoints)
-	if curIndex >= lens {
-		curIndex = 0
+	lens := uint64(len(endpoints))
+
+	counter, ok := roundRobinIndex.Load(des.ClusterKey())
+	if !ok {
+		counter, _ = roundRobinIndex.LoadOrStore(des.ClusterKey(), new(uint64))
 	}
+	start := atomic.AddUint64(counter.(*uint64), 1) - 1
 
-	inst = des.Endpoints[curIndex]
-	curIndex = (curIndex + 1) % lens
+	for i := uint64(0); i < lens; i++ {
+		candidate := endpoints[(start+i)%lens]
+
+		if endpointAvailable(des, candidate) {
+			inst = candidate
+			return
+		}
+	}
+
+	err = errors.New("no available endpoints")
 	return
 }
 
@@ -448,12 +1001,25 @@ type Endpoint struct {
 	Status util.YesOrNo
 }
 
+// GetProtocol Protocol无法识别时视为TCP
+func (ed *Endpoint) GetProtocol() ListenerProtocol {
+	if protocol, ok := ParseListenerProtocol(ed.Protocol); ok {
+		return protocol
+	}
+	return LpTcp
+}
+
 func (ed *Endpoint) ToString() string {
 	if ed == nil {
 		return ""
 	}
 
-	return stringJoin("http://", ed.Ip, ":", strconv.FormatInt(int64(ed.Port), 10))
+	scheme := "http://"
+	if ed.GetProtocol().IsTLS() {
+		scheme = "https://"
+	}
+
+	return stringJoin(scheme, ed.Ip, ":", strconv.FormatInt(int64(ed.Port), 10))
 }
 
 func stringJoin(args ...string) string {
@@ -0,0 +1,130 @@
+package envoy
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func TestPortsGetProtocol(t *testing.T) {
+	cases := []struct {
+		port     Ports
+		expected ListenerProtocol
+	}{
+		{Ports{Name: "order"}, LpTcp},
+		{Ports{Name: "order-http"}, LpHttp},
+		{Ports{Name: "order-http2"}, LpHttp2},
+		{Ports{Name: "order-grpc", Protocol: "TCP"}, LpGrpc},
+		{Ports{Name: "order-unknown"}, LpTcp},
+		{Ports{Name: "order-http", Protocol: "HTTPS"}, LpHttps},
+		{Ports{Name: "order-http", Protocol: "TCP", AppProtocol: "kubernetes.io/ws"}, LpWebSocket},
+		{Ports{Name: "order-http", AppProtocol: "kubernetes.io/h2c"}, LpHttp2},
+		{Ports{Name: "order-tls", AppProtocol: "unknown"}, LpTls},
+		{Ports{Name: "order-grpc", AppProtocol: "HTTP/1.1"}, LpHttp},
+	}
+
+	for _, c := range cases {
+		if actual := c.port.GetProtocol(); actual != c.expected {
+			t.Errorf("%+v: expected %s, got %s", c.port, c.expected, actual)
+		}
+	}
+}
+
+func TestEndpointToString(t *testing.T) {
+	if s := (&Endpoint{Ip: "10.0.0.1", Port: 80}).ToString(); s != "http://10.0.0.1:80" {
+		t.Fatal(s)
+	}
+	if s := (&Endpoint{Ip: "10.0.0.1", Port: 443, Protocol: "HTTPS"}).ToString(); s != "https://10.0.0.1:443" {
+		t.Fatal(s)
+	}
+}
+
+func TestLDS_GetMirror(t *testing.T) {
+	route := &HTTPRoute{Mirror: &MirrorPolicy{ClusterName: "order-shadow", Percentage: 10}}
+
+	if (&LDS{}).GetMirror(route) != nil {
+		t.Fatal("mirror should be disabled without EnableMirror")
+	}
+	if (&LDS{EnableMirror: true}).GetMirror(route) == nil {
+		t.Fatal("mirror should be enabled")
+	}
+	if (&LDS{EnableMirror: true}).GetMirror(&HTTPRoute{}) != nil {
+		t.Fatal("route without mirror policy should not be mirrored")
+	}
+}
+
+func TestInternalUsers_RouteTag(t *testing.T) {
+	users := &InternalUsers{Users: []*InternalUser{
+		{UserId: "1001", Status: util.Yes},
+		{Guid: "g-2002", Tags: []string{"beta"}, Status: util.Yes},
+		{UserId: "3003", Status: util.No},
+	}}
+	route := &HTTPRoute{BindInternalUsers: true, BindInternalUserTag: "canary"}
+
+	if tag, ok := users.RouteTag(route, "1001", ""); !ok || tag != "canary" {
+		t.Fatalf("internal user should route to canary, got %s %v", tag, ok)
+	}
+	if _, ok := users.RouteTag(route, "", "g-2002"); ok {
+		t.Fatal("user without canary tag should not be routed")
+	}
+	if _, ok := users.RouteTag(route, "3003", ""); ok {
+		t.Fatal("disabled user should not be routed")
+	}
+	if _, ok := users.RouteTag(&HTTPRoute{BindInternalUserTag: "canary"}, "1001", ""); ok {
+		t.Fatal("route without BindInternalUsers should not be routed")
+	}
+	if _, ok := (*InternalUsers)(nil).RouteTag(route, "1001", ""); ok {
+		t.Fatal("nil users should not be routed")
+	}
+}
+
+func TestEDS_GetVersionEndpoint(t *testing.T) {
+	eds := &EDS{Endpoints: []*Endpoint{
+		{Ip: "10.0.0.1", Version: "prod"},
+		{Ip: "10.0.0.2", Version: "canary"},
+	}}
+
+	for i := 0; i < 3; i++ {
+		ep, err := eds.GetVersionEndpoint("canary")
+		if err != nil || ep.Ip != "10.0.0.2" {
+			t.Fatalf("unexpected endpoint %+v %v", ep, err)
+		}
+	}
+	if _, err := eds.GetVersionEndpoint("beta"); err == nil {
+		t.Fatal("expected error for version without endpoints")
+	}
+}
+
+func TestEDS_GetEndpointConcurrent(t *testing.T) {
+	eds := &EDS{NodeName: "round-robin", Endpoints: []*Endpoint{{Ip: "10.0.0.1"}, {Ip: "10.0.0.2"}}}
+
+	var wg sync.WaitGroup
+	counts := make([]map[string]int, 4)
+	for i := range counts {
+		counts[i] = make(map[string]int)
+		wg.Add(1)
+		go func(count map[string]int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ep, err := eds.GetEndpoint()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				count[ep.Ip]++
+			}
+		}(counts[i])
+	}
+	wg.Wait()
+
+	total := make(map[string]int)
+	for _, count := range counts {
+		for ip, n := range count {
+			total[ip] += n
+		}
+	}
+	if total["10.0.0.1"] != 200 || total["10.0.0.2"] != 200 {
+		t.Fatalf("endpoints should be picked evenly, got %v", total)
+	}
+}
@@ -0,0 +1,159 @@
+package envoy
+
+import (
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mgcicd/cicd-core/util"
+)
+
+// sections 规范化后的配置，每个分区是排序后的条目集合，与切片顺序无关
+type sections map[string][]string
+
+func (s sections) add(section string, entry string) {
+	s[section] = append(s[section], entry)
+}
+
+func (s sections) addJson(section string, v interface{}) {
+	s.add(section, util.StructToJson(v))
+}
+
+func (s sections) hash() string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+		sort.Strings(s[name])
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	for _, name := range names {
+		builder.WriteString(name)
+		builder.WriteString(":")
+		builder.WriteString(strings.Join(s[name], "\n"))
+		builder.WriteString(";")
+	}
+
+	return fmt.Sprintf("%x", md5.Sum([]byte(builder.String())))
+}
+
+func sortedCopy(values []string) []string {
+	result := append([]string(nil), values...)
+	sort.Strings(result)
+	return result
+}
+
+// cdsSections Version字段是配置本身的版本号，不参与计算
+func (eds *EDS) cdsSections() sections {
+	s := sections{}
+
+	s.addJson("cluster", struct {
+		Name             string
+		ClusterIp        string
+		NetflowTagEnable int
+		K8SKindId        EDS_K8SKindId
+		GrayStrategy     int
+	}{eds.Name, eds.ClusterIp, eds.NetflowTagEnable, eds.K8SKindId, eds.GrayStrategy})
+
+	for _, ep := range eds.Endpoints {
+		if ep != nil {
+			s.addJson("endpoints", ep)
+		}
+	}
+
+	for _, port := range eds.Ports {
+		if port != nil {
+			s.addJson("ports", port)
+		}
+	}
+
+	for _, version := range eds.EDSVersions {
+		policys := append([]EDS_Version_Policy(nil), version.Policys...)
+		sort.Slice(policys, func(i, j int) bool { return policys[i] < policys[j] })
+
+		version.Policys = policys
+		version.NetflowTag = sortedCopy(version.NetflowTag)
+		s.addJson("versions", version)
+	}
+
+	s.add("circuitBreaker", "enable="+strconv.Itoa(eds.EnableCircuitbreaker))
+	for _, cb := range eds.CircuitBreaker {
+		if cb != nil {
+			s.addJson("circuitBreaker", cb)
+		}
+	}
+
+	s.add("outlierDetection", "enable="+strconv.Itoa(int(eds.EnableOutlierDetection)))
+	s.addJson("outlierDetection", eds.OutlierDetection)
+
+	s.add("healthCheck", "enable="+strconv.Itoa(int(eds.EnableHealthCheck)))
+	s.addJson("healthCheck", eds.HealthCheck)
+
+	return s
+}
+
+// GetCdsVersion EDS内容的规范化md5，与Endpoints、Ports、EDSVersions等切片的顺序无关
+// 覆盖endpoint、端口、版本权重、熔断、异常点检测、健康检查等所有影响路由的字段，没有endpoint时返回空串，两个版本的结构化差异见config/diff
+func (eds *EDS) GetCdsVersion() string {
+	if eds == nil || eds.Endpoints == nil || len(eds.Endpoints) == 0 {
+		return ""
+	}
+
+	return eds.cdsSections().hash()
+}
+
+// ldsSections 插件按声明顺序执行，Regex路由按声明顺序匹配，这两类条目带上序号；其余条目与顺序无关
+func (l *LDS) ldsSections() sections {
+	s := sections{}
+
+	s.addJson("lds", struct {
+		Name           string
+		RouteMatchType RouteMatchType
+		EnableMirror   bool
+	}{l.Name, l.RouteMatchType, l.EnableMirror})
+
+	for i, plugin := range l.Plugins {
+		if plugin != nil {
+			s.add("plugins", strconv.Itoa(i)+"|"+util.StructToJson(plugin))
+		}
+	}
+
+	for _, listener := range l.Listeners {
+		if listener == nil {
+			continue
+		}
+
+		domains := strings.Join(sortedCopy(listener.Domains), ",")
+		entry := domains + "|tls=" + strconv.FormatBool(listener.EnableTLS)
+		if listener.TLS != nil {
+			entry += "|" + util.StructToJson(listener.TLS)
+		}
+		s.add("listeners", entry)
+
+		for i, route := range listener.Routes {
+			if route == nil {
+				continue
+			}
+
+			entry := domains + "|" + util.StructToJson(route)
+			if l.RouteMatchType == Regex {
+				entry = domains + "|" + strconv.Itoa(i) + "|" + util.StructToJson(route)
+			}
+			s.add("routes", entry)
+		}
+	}
+
+	return s
+}
+
+// GetLdsVersion LDS内容的规范化md5，Version字段不参与计算
+func (l *LDS) GetLdsVersion() string {
+	if l == nil {
+		return ""
+	}
+
+	return l.ldsSections().hash()
+}
@@ -0,0 +1,86 @@
+package envoy
+
+import (
+	"testing"
+
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func newTestEDS() *EDS {
+	return &EDS{
+		Name:      "order",
+		ClusterIp: "10.96.0.1",
+		Endpoints: []*Endpoint{
+			{Ip: "10.0.0.1", Port: 80, Name: "order-a", Version: "prod"},
+			{Ip: "10.0.0.2", Port: 80, Name: "order-b", Version: "canary"},
+		},
+		Ports: []*Ports{{Name: "order-http", Port: 80}},
+		EDSVersions: []EDS_Version{
+			{Version: "prod", FlowWeight: 90, Policys: []EDS_Version_Policy{A, B}},
+			{Version: "canary", FlowWeight: 10, NetflowTag: []string{"x", "y"}},
+		},
+	}
+}
+
+func TestGetCdsVersion_OrderIndependent(t *testing.T) {
+	a := newTestEDS()
+	b := newTestEDS()
+
+	b.Endpoints[0], b.Endpoints[1] = b.Endpoints[1], b.Endpoints[0]
+	b.EDSVersions[0], b.EDSVersions[1] = b.EDSVersions[1], b.EDSVersions[0]
+	b.EDSVersions[0].NetflowTag = []string{"y", "x"}
+	b.EDSVersions[1].Policys = []EDS_Version_Policy{B, A}
+	b.Version = "another"
+
+	if a.GetCdsVersion() != b.GetCdsVersion() {
+		t.Fatal("reordered eds should have the same version")
+	}
+}
+
+func TestGetCdsVersion_Changes(t *testing.T) {
+	changes := map[string]func(eds *EDS){
+		"port":         func(eds *EDS) { eds.Endpoints[0].Port = 8080 },
+		"weight":       func(eds *EDS) { eds.EDSVersions[1].FlowWeight = 20 },
+		"status":       func(eds *EDS) { eds.Endpoints[1].Status = util.No },
+		"breaker":      func(eds *EDS) { eds.EnableCircuitbreaker = int(util.True) },
+		"health check": func(eds *EDS) { eds.HealthCheck.HttpHealthCheck.Path = "/health" },
+		"service port": func(eds *EDS) { eds.Ports[0].TargetPort = 8080 },
+	}
+
+	for name, change := range changes {
+		eds := newTestEDS()
+		change(eds)
+
+		if eds.GetCdsVersion() == newTestEDS().GetCdsVersion() {
+			t.Errorf("%s change should change the version", name)
+		}
+	}
+}
+
+func TestGetLdsVersion(t *testing.T) {
+	newLDS := func() *LDS {
+		return &LDS{
+			Name:           "order",
+			RouteMatchType: Prefix,
+			Listeners: []*Listener{{
+				Domains: []string{"a.example.com", "b.example.com"},
+				Routes:  []*HTTPRoute{{Prefix: "/a", ClusterName: "a"}, {Prefix: "/b", ClusterName: "b"}},
+			}},
+		}
+	}
+
+	a := newLDS()
+	b := newLDS()
+	b.Listeners[0].Domains = []string{"b.example.com", "a.example.com"}
+	b.Listeners[0].Routes[0], b.Listeners[0].Routes[1] = b.Listeners[0].Routes[1], b.Listeners[0].Routes[0]
+
+	if a.GetLdsVersion() != b.GetLdsVersion() {
+		t.Fatal("reordered prefix routes should have the same version")
+	}
+
+	a.RouteMatchType = Regex
+	b.RouteMatchType = Regex
+	if a.GetLdsVersion() == b.GetLdsVersion() {
+		t.Fatal("regex routes are matched in order and should change the version")
+	}
+}
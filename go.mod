@@ -4,19 +4,17 @@ go 1.14
 
 require (
 	github.com/Shopify/sarama v1.27.0
-	github.com/golang/protobuf v1.4.3
+	github.com/envoyproxy/go-control-plane v0.9.9
+	github.com/golang/protobuf v1.5.0
 	github.com/json-iterator/go v1.1.11
 	github.com/klauspost/compress v1.11.8 // indirect
-	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.1 // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/samuel/go-zookeeper v0.0.0-20180130194729-c4fab1ac1bec
-	github.com/stretchr/testify v1.6.1 // indirect
 	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83 // indirect
-	golang.org/x/net v0.0.0-20210525063256-abc453219eb5 // indirect
-	golang.org/x/sys v0.0.0-20210603081109-ebe580a85c40 // indirect
-	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/genproto v0.0.0-20200825200019-8632dd797987
-	google.golang.org/grpc v1.31.0
-	google.golang.org/protobuf v1.26.0-rc.1 // indirect
+	google.golang.org/grpc v1.36.0
+	google.golang.org/protobuf v1.26.0
+	k8s.io/api v0.21.14
+	k8s.io/apimachinery v0.21.14
+	sigs.k8s.io/yaml v1.2.0
 )
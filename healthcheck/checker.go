@@ -0,0 +1,276 @@
+package healthcheck
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+// Transition endpoint健康状态变化
+type Transition struct {
+	Cluster  string
+	Endpoint *envoy.Endpoint
+	Healthy  bool
+	Time     time.Time
+}
+
+// Checker 对开启了EnableHealthCheck的集群中每个endpoint定时发起HTTP探测
+// 集群从/cds删除、关闭健康检查或endpoint下线时停止对应的探测
+// 通过envoy.RegisterEndpointFilter注册后，负载均衡时会跳过不健康的endpoint
+type Checker struct {
+	client      *http.Client
+	manager     *common.Manager
+	mu          sync.Mutex
+	probes      map[string]map[string]*probe
+	transitions chan Transition
+	stop        chan struct{}
+}
+
+func NewChecker(m *common.Manager) *Checker {
+	c := &Checker{
+		client:      &http.Client{},
+		manager:     m,
+		probes:      make(map[string]map[string]*probe),
+		transitions: make(chan Transition, 64),
+		stop:        make(chan struct{}),
+	}
+
+	cds := m.Subscribe("/cds/")
+
+	c.Reload()
+
+	go c.watch(cds)
+
+	return c
+}
+
+func (c *Checker) watch(cds <-chan common.ChangedEvent) {
+	for {
+		select {
+		case <-cds:
+			c.Reload()
+		case <-c.stop:
+			c.manager.Unsubscribe(cds)
+			return
+		}
+	}
+}
+
+// Close 停止所有探测
+func (c *Checker) Close() {
+	close(c.stop)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for cluster, probes := range c.probes {
+		for _, p := range probes {
+			p.close()
+		}
+		delete(c.probes, cluster)
+	}
+}
+
+// Transitions 健康状态变化事件，处理不及时时事件会被丢弃
+func (c *Checker) Transitions() <-chan Transition {
+	return c.transitions
+}
+
+func hostKey(ep *envoy.Endpoint) string {
+	return ep.Ip + ":" + strconv.Itoa(ep.Port)
+}
+
+// Reload 按当前/cds配置增删探测任务
+func (c *Checker) Reload() {
+	desired := make(map[string]*envoy.EDS)
+	for _, eds := range c.manager.GetAllCds() {
+		if eds.EnableHealthCheck == util.True && eds.HealthCheck.HttpHealthCheck.Path != "" {
+			desired[eds.ClusterKey()] = eds
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for cluster, probes := range c.probes {
+		eds := desired[cluster]
+
+		for key, p := range probes {
+			if eds == nil || !containsEndpoint(eds, key) || p.check != eds.HealthCheck {
+				p.close()
+				delete(probes, key)
+			}
+		}
+
+		if len(probes) == 0 {
+			delete(c.probes, cluster)
+		}
+	}
+
+	for cluster, eds := range desired {
+		probes := c.probes[cluster]
+		if probes == nil {
+			probes = make(map[string]*probe)
+			c.probes[cluster] = probes
+		}
+
+		for _, ep := range eds.Endpoints {
+			if ep == nil || probes[hostKey(ep)] != nil {
+				continue
+			}
+
+			p := newProbe(c, cluster, ep, eds.HealthCheck)
+			probes[hostKey(ep)] = p
+			go p.run()
+		}
+	}
+}
+
+func containsEndpoint(eds *envoy.EDS, key string) bool {
+	for _, ep := range eds.Endpoints {
+		if ep != nil && hostKey(ep) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Healthy cluster为EDS.ClusterKey()，未被探测的endpoint视为健康
+func (c *Checker) Healthy(cluster string, ep *envoy.Endpoint) bool {
+	c.mu.Lock()
+	p := c.probes[cluster][hostKey(ep)]
+	c.mu.Unlock()
+
+	return p == nil || p.isHealthy()
+}
+
+// Available 实现envoy.EndpointFilter，未开启健康检查的集群始终可用
+func (c *Checker) Available(eds *envoy.EDS, ep *envoy.Endpoint) bool {
+	if eds.EnableHealthCheck != util.True {
+		return true
+	}
+
+	return c.Healthy(eds.ClusterKey(), ep)
+}
+
+func (c *Checker) emit(t Transition) {
+	select {
+	case c.transitions <- t:
+	default:
+	}
+}
+
+type probe struct {
+	checker  *Checker
+	cluster  string
+	endpoint *envoy.Endpoint
+	check    envoy.HealthCheck
+	stop     chan struct{}
+
+	mu        sync.Mutex
+	healthy   bool
+	successes uint32
+	failures  uint32
+}
+
+// newProbe endpoint初始视为健康，避免新集群在首次探测完成前无可用实例
+func newProbe(c *Checker, cluster string, ep *envoy.Endpoint, check envoy.HealthCheck) *probe {
+	return &probe{
+		checker:  c,
+		cluster:  cluster,
+		endpoint: ep,
+		check:    check,
+		stop:     make(chan struct{}),
+		healthy:  true,
+	}
+}
+
+func (p *probe) close() {
+	close(p.stop)
+}
+
+func (p *probe) isHealthy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.healthy
+}
+
+func (p *probe) run() {
+	ticker := time.NewTicker(p.check.GetInterval())
+	defer ticker.Stop()
+
+	for {
+		p.record(p.do())
+
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *probe) do() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.check.GetTimeout())
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, p.endpoint.ToString()+p.check.HttpHealthCheck.Path, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := p.checker.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func (p *probe) record(success bool) {
+	p.mu.Lock()
+
+	var changed bool
+
+	if success {
+		p.failures = 0
+		p.successes++
+		if !p.healthy && p.successes >= p.check.GetHealthyThreshold() {
+			p.healthy = true
+			changed = true
+		}
+	} else {
+		p.successes = 0
+		p.failures++
+		if p.healthy && p.failures >= p.check.GetUnhealthyThreshold() {
+			p.healthy = false
+			changed = true
+		}
+	}
+
+	healthy := p.healthy
+	p.mu.Unlock()
+
+	if changed {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		p.checker.emit(Transition{
+			Cluster:  p.cluster,
+			Endpoint: p.endpoint,
+			Healthy:  healthy,
+			Time:     time.Now(),
+		})
+	}
+}
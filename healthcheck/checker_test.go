@@ -0,0 +1,87 @@
+package healthcheck
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func TestChecker(t *testing.T) {
+	var status int32 = http.StatusOK
+	var probes int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			atomic.AddInt32(&probes, 1)
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	ep := &envoy.Endpoint{Ip: host, Port: p}
+
+	eds := &envoy.EDS{
+		Endpoints:         []*envoy.Endpoint{ep},
+		EnableHealthCheck: util.True,
+		HealthCheck: envoy.HealthCheck{
+			IntervalMs:         10,
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 2,
+			HttpHealthCheck:    envoy.HttpHealthCheck{Path: "/health"},
+		},
+	}
+
+	m := &common.Manager{}
+	m.SetCache("/cds/order", util.StructToJson(eds))
+
+	c := NewChecker(m)
+	defer c.Close()
+
+	//集群没有Name，探测和负载均衡都以/cds节点名为准
+	eds = m.GetAllCds()["order"]
+
+	if !c.Available(eds, ep) {
+		t.Fatal("endpoint should be healthy before the first failed probe")
+	}
+
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	transition := waitTransition(t, c)
+	if transition.Healthy || transition.Cluster != "order" || c.Available(eds, ep) {
+		t.Fatalf("expected unhealthy transition, got %+v", transition)
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	if transition := waitTransition(t, c); !transition.Healthy || !c.Available(eds, ep) {
+		t.Fatalf("expected healthy transition, got %+v", transition)
+	}
+
+	m.DeleteCache("/cds/order")
+	time.Sleep(50 * time.Millisecond)
+
+	before := atomic.LoadInt32(&probes)
+	time.Sleep(50 * time.Millisecond)
+	if after := atomic.LoadInt32(&probes); after != before {
+		t.Fatalf("probes should stop after the cluster is removed, got %d more", after-before)
+	}
+}
+
+func waitTransition(t *testing.T, c *Checker) Transition {
+	select {
+	case transition := <-c.Transitions():
+		return transition
+	case <-time.After(2 * time.Second):
+		t.Fatal("no transition received")
+	}
+
+	return Transition{}
+}
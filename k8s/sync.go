@@ -0,0 +1,353 @@
+package k8s
+
+import (
+	"errors"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+)
+
+const (
+	serviceNameLabel    = "kubernetes.io/service-name"
+	defaultVersionLabel = "version"
+)
+
+// Client 读取Kubernetes对象，便于测试时替换为fake
+type Client interface {
+	ListServices(namespace string) ([]corev1.Service, error)
+	ListEndpointSlices(namespace string) ([]discoveryv1.EndpointSlice, error)
+	ListPods(namespace string) ([]corev1.Pod, error)
+}
+
+// Store 读写/cds节点，*common.Manager实现了该接口
+type Store interface {
+	GetAllCds() map[string]*envoy.EDS
+	Exists(path string) bool
+	Create(name string, v interface{}) error
+	Set(path string, v interface{}) error
+	Delete(name string) error
+}
+
+// Synchronizer 把Service和EndpointSlice转换为envoy.EDS写入/cds/{service name}，EDS.Namespace记录节点归属的命名空间
+// ClusterIp、Ports、Endpoints由Kubernetes决定，其余配置(版本流量、熔断、健康检查等)保留/cds中已有的值
+// 只有同步器维护的字段变化时才写入；Service删除后，同步范围内归属于它的节点随之删除
+// 不同命名空间的同名Service只同步节点已归属的那一个，无法确定归属时都不同步并返回错误，避免互相覆盖
+// 已有的Namespace为空的节点不由同步器维护，Adopt为true时才由同名Service接管
+type Synchronizer struct {
+	// Adopt 接管Namespace为空的已有节点，用于从手工维护迁移到同步
+	Adopt bool
+
+	client       Client
+	store        Store
+	namespace    string
+	versionLabel string
+}
+
+// NewSynchronizer namespace为空表示所有命名空间，versionLabel为空时使用pod的version标签
+func NewSynchronizer(client Client, store Store, namespace string, versionLabel string) *Synchronizer {
+	if versionLabel == "" {
+		versionLabel = defaultVersionLabel
+	}
+
+	return &Synchronizer{
+		client:       client,
+		store:        store,
+		namespace:    namespace,
+		versionLabel: versionLabel,
+	}
+}
+
+// Run 每隔interval同步一次，直到stop关闭
+func (s *Synchronizer) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sync(); err != nil {
+			log.Println("k8s sync failed:", err.Error())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Sync 同步一次，返回写入或删除了/cds的service name
+func (s *Synchronizer) Sync() ([]string, error) {
+	services, err := s.client.ListServices(s.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	slices, err := s.client.ListEndpointSlices(s.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := s.client.ListPods(s.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	podLabels := make(map[string]map[string]string)
+	for i := range pods {
+		podLabels[pods[i].Namespace+"/"+pods[i].Name] = pods[i].Labels
+	}
+
+	slicesByService := make(map[string][]*discoveryv1.EndpointSlice)
+	for i := range slices {
+		key := slices[i].Namespace + "/" + slices[i].Labels[serviceNameLabel]
+		slicesByService[key] = append(slicesByService[key], &slices[i])
+	}
+
+	existing := s.store.GetAllCds()
+
+	byName := make(map[string][]*corev1.Service)
+	for i := range services {
+		byName[services[i].Name] = append(byName[services[i].Name], &services[i])
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var changed []string
+	var errs []string
+
+	for _, name := range names {
+		old := existing[name]
+		if old != nil && old.Namespace == "" && !s.Adopt {
+			continue
+		}
+
+		svc := owner(byName[name], old)
+		if svc == nil {
+			namespaces := make([]string, 0, len(byName[name]))
+			for _, candidate := range byName[name] {
+				namespaces = append(namespaces, candidate.Namespace)
+			}
+			sort.Strings(namespaces)
+			errs = append(errs, "service "+name+" exists in namespaces "+strings.Join(namespaces, ", "))
+			continue
+		}
+
+		eds := s.convert(svc, slicesByService[svc.Namespace+"/"+svc.Name], podLabels, old)
+
+		if old != nil && !changedFields(old, eds) {
+			continue
+		}
+
+		if err := s.write(name, eds); err != nil {
+			return changed, err
+		}
+		changed = append(changed, name)
+	}
+
+	removed := make([]string, 0)
+	for name, eds := range existing {
+		if eds.Namespace == "" || (s.namespace != "" && eds.Namespace != s.namespace) || len(byName[name]) > 0 {
+			continue
+		}
+		removed = append(removed, name)
+	}
+	sort.Strings(removed)
+
+	for _, name := range removed {
+		if err := s.store.Delete("/cds/" + name); err != nil {
+			return changed, err
+		}
+		changed = append(changed, name)
+	}
+
+	if len(errs) > 0 {
+		return changed, errors.New(strings.Join(errs, "; "))
+	}
+	return changed, nil
+}
+
+// changedFields 比较同步器维护的字段，GetCdsVersion在没有endpoint时为空，不能用于判断变化
+func changedFields(old *envoy.EDS, eds *envoy.EDS) bool {
+	return old.Name != eds.Name ||
+		old.Namespace != eds.Namespace ||
+		old.ClusterIp != eds.ClusterIp ||
+		!reflect.DeepEqual(old.Ports, eds.Ports) ||
+		!reflect.DeepEqual(old.Endpoints, eds.Endpoints) ||
+		!reflect.DeepEqual(old.EDSVersions, eds.EDSVersions)
+}
+
+// owner 同名Service中/cds节点归属的那一个，归属的Service已删除且只剩一个同名Service时由它接管，无法确定时返回nil
+func owner(services []*corev1.Service, old *envoy.EDS) *corev1.Service {
+	if old != nil && old.Namespace != "" {
+		for _, svc := range services {
+			if svc.Namespace == old.Namespace {
+				return svc
+			}
+		}
+	}
+
+	if len(services) == 1 {
+		return services[0]
+	}
+
+	return nil
+}
+
+func (s *Synchronizer) write(name string, eds *envoy.EDS) error {
+	path := "/cds/" + name
+	eds.Version = eds.GetCdsVersion()
+
+	if s.store.Exists(path) {
+		return s.store.Set(path, eds)
+	}
+	return s.store.Create(path, eds)
+}
+
+func (s *Synchronizer) convert(svc *corev1.Service, slices []*discoveryv1.EndpointSlice, podLabels map[string]map[string]string, old *envoy.EDS) *envoy.EDS {
+	eds := &envoy.EDS{}
+	if old != nil {
+		copied := *old
+		eds = &copied
+	}
+
+	eds.Name = svc.Name
+	eds.Namespace = svc.Namespace
+	eds.ClusterIp = svc.Spec.ClusterIP
+
+	eds.Ports = make([]*envoy.Ports, 0, len(svc.Spec.Ports))
+	portsByName := make(map[string]*envoy.Ports)
+	for _, p := range svc.Spec.Ports {
+		port := &envoy.Ports{
+			Name:       p.Name,
+			Protocol:   string(p.Protocol),
+			Port:       int(p.Port),
+			NodePort:   int(p.NodePort),
+			TargetPort: p.TargetPort.IntValue(),
+		}
+		if p.AppProtocol != nil {
+			port.AppProtocol = *p.AppProtocol
+		}
+
+		eds.Ports = append(eds.Ports, port)
+		portsByName[p.Name] = port
+	}
+
+	previous := make(map[string]*envoy.Endpoint)
+	if old != nil {
+		for _, ep := range old.Endpoints {
+			if ep != nil {
+				previous[ep.Ip+":"+strconv.Itoa(ep.Port)] = ep
+			}
+		}
+	}
+
+	eds.Endpoints = make([]*envoy.Endpoint, 0)
+	versions := make(map[string]bool)
+
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+
+			var name, version string
+			if ref := endpoint.TargetRef; ref != nil && ref.Kind == "Pod" {
+				name = ref.Name
+				version = podLabels[slice.Namespace+"/"+ref.Name][s.versionLabel]
+			}
+			if version != "" {
+				versions[version] = true
+			}
+
+			for _, port := range slice.Ports {
+				if port.Port == nil {
+					continue
+				}
+
+				//EndpointSlice的端口名与Service端口名一致，协议按Service端口解析
+				resolved := &envoy.Ports{}
+				if port.Name != nil {
+					if p, ok := portsByName[*port.Name]; ok {
+						copied := *p
+						resolved = &copied
+					}
+				}
+				if port.AppProtocol != nil {
+					resolved.AppProtocol = *port.AppProtocol
+				}
+				if port.Protocol != nil && resolved.Protocol == "" {
+					resolved.Protocol = string(*port.Protocol)
+				}
+				protocol := string(resolved.GetProtocol())
+
+				for _, address := range endpoint.Addresses {
+					ep := &envoy.Endpoint{
+						Namespace: slice.Namespace,
+						Ip:        address,
+						Port:      int(*port.Port),
+						Protocol:  protocol,
+						Version:   version,
+						Name:      name,
+						Status:    util.Yes,
+					}
+
+					//权重和启用状态由配置中心维护
+					if p, ok := previous[address+":"+strconv.Itoa(ep.Port)]; ok {
+						ep.Weight = p.Weight
+						ep.Status = p.Status
+					}
+
+					eds.Endpoints = append(eds.Endpoints, ep)
+				}
+			}
+		}
+	}
+
+	sort.Slice(eds.Endpoints, func(i, j int) bool {
+		a, b := eds.Endpoints[i], eds.Endpoints[j]
+		if a.Ip != b.Ip {
+			return a.Ip < b.Ip
+		}
+		return a.Port < b.Port
+	})
+
+	eds.EDSVersions = mergeVersions(eds.EDSVersions, versions)
+
+	return eds
+}
+
+// mergeVersions 保留已有版本的流量配置，为pod上新出现的版本补充默认配置
+func mergeVersions(existing []envoy.EDS_Version, versions map[string]bool) []envoy.EDS_Version {
+	result := append([]envoy.EDS_Version(nil), existing...)
+
+	known := make(map[string]bool)
+	for _, v := range existing {
+		known[strings.ToLower(v.Version)] = true
+	}
+
+	added := make([]string, 0)
+	for version := range versions {
+		if !known[strings.ToLower(version)] {
+			added = append(added, version)
+		}
+	}
+	sort.Strings(added)
+
+	for _, version := range added {
+		result = append(result, envoy.EDS_Version{Version: version, Enable: util.Yes})
+	}
+
+	return result
+}
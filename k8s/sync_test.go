@@ -0,0 +1,209 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+type fakeClient struct {
+	services []corev1.Service
+	slices   []discoveryv1.EndpointSlice
+	pods     []corev1.Pod
+}
+
+func (c *fakeClient) ListServices(namespace string) ([]corev1.Service, error) {
+	return c.services, nil
+}
+
+func (c *fakeClient) ListEndpointSlices(namespace string) ([]discoveryv1.EndpointSlice, error) {
+	return c.slices, nil
+}
+
+func (c *fakeClient) ListPods(namespace string) ([]corev1.Pod, error) {
+	return c.pods, nil
+}
+
+type fakeStore struct {
+	cds    map[string]*envoy.EDS
+	writes int
+}
+
+func (s *fakeStore) GetAllCds() map[string]*envoy.EDS {
+	return s.cds
+}
+
+func (s *fakeStore) Exists(path string) bool {
+	_, ok := s.cds[path[len("/cds/"):]]
+	return ok
+}
+
+func (s *fakeStore) Create(name string, v interface{}) error {
+	return s.Set(name, v)
+}
+
+func (s *fakeStore) Set(path string, v interface{}) error {
+	eds := new(envoy.EDS)
+	util.JsonToStruct(util.StructToJson(v), eds)
+	s.cds[path[len("/cds/"):]] = eds
+	s.writes++
+	return nil
+}
+
+func (s *fakeStore) Delete(name string) error {
+	delete(s.cds, name[len("/cds/"):])
+	s.writes++
+	return nil
+}
+
+func newFakeClient() *fakeClient {
+	port := int32(8080)
+	portName := "order-http"
+	protocol := corev1.ProtocolTCP
+	ready := true
+	notReady := false
+
+	return &fakeClient{
+		services: []corev1.Service{{
+			ObjectMeta: metav1.ObjectMeta{Name: "order", Namespace: "shop"},
+			Spec: corev1.ServiceSpec{
+				ClusterIP: "10.96.0.10",
+				Ports:     []corev1.ServicePort{{Name: "order-http", Protocol: corev1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt(8080)}},
+			},
+		}},
+		slices: []discoveryv1.EndpointSlice{{
+			ObjectMeta: metav1.ObjectMeta{Name: "order-abc", Namespace: "shop", Labels: map[string]string{serviceNameLabel: "order"}},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}, TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "order-canary-1"}},
+				{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}, TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "order-prod-1"}},
+				{Addresses: []string{"10.0.0.3"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}, TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "order-prod-2"}},
+			},
+			Ports: []discoveryv1.EndpointPort{{Name: &portName, Port: &port, Protocol: &protocol}},
+		}},
+		pods: []corev1.Pod{
+			{ObjectMeta: metav1.ObjectMeta{Name: "order-prod-1", Namespace: "shop", Labels: map[string]string{"version": "prod"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "order-canary-1", Namespace: "shop", Labels: map[string]string{"version": "canary"}}},
+		},
+	}
+}
+
+func TestSync(t *testing.T) {
+	client := newFakeClient()
+	store := &fakeStore{cds: map[string]*envoy.EDS{
+		"order": {
+			Name:                 "order",
+			EnableCircuitbreaker: 1,
+			EDSVersions:          []envoy.EDS_Version{{Version: "prod", FlowWeight: 100}},
+			Endpoints:            []*envoy.Endpoint{{Ip: "10.0.0.1", Port: 8080, Status: util.No}},
+		},
+	}}
+
+	s := NewSynchronizer(client, store, "shop", "")
+
+	//Namespace为空的节点不由同步器维护
+	if changed, err := s.Sync(); err != nil || len(changed) != 0 || store.writes != 0 {
+		t.Fatalf("unmanaged node should not be adopted: %v %v", changed, err)
+	}
+
+	s.Adopt = true
+	changed, err := s.Sync()
+	if err != nil || len(changed) != 1 || store.writes != 1 {
+		t.Fatalf("unexpected sync result %v %v %d", changed, err, store.writes)
+	}
+
+	eds := store.cds["order"]
+	if eds.ClusterIp != "10.96.0.10" || len(eds.Ports) != 1 || eds.Ports[0].TargetPort != 8080 {
+		t.Fatalf("unexpected service fields %s", util.StructToJson(eds))
+	}
+	if eds.EnableCircuitbreaker != 1 {
+		t.Fatal("existing config should be kept")
+	}
+	if len(eds.Endpoints) != 2 || eds.Endpoints[0].Ip != "10.0.0.1" || eds.Endpoints[0].Version != "prod" || eds.Endpoints[0].Status != util.No {
+		t.Fatalf("unexpected endpoints %s", util.StructToJson(eds.Endpoints))
+	}
+	if eds.Endpoints[1].Version != "canary" || eds.Endpoints[1].Name != "order-canary-1" || eds.Endpoints[1].Namespace != "shop" || eds.Endpoints[1].Protocol != string(envoy.LpHttp) {
+		t.Fatalf("unexpected endpoints %s", util.StructToJson(eds.Endpoints))
+	}
+	if len(eds.EDSVersions) != 2 || eds.EDSVersions[0].FlowWeight != 100 || eds.EDSVersions[1].Version != "canary" {
+		t.Fatalf("unexpected versions %s", util.StructToJson(eds.EDSVersions))
+	}
+	if eds.Version != eds.GetCdsVersion() {
+		t.Fatal("version should be the cds version")
+	}
+
+	if changed, _ := s.Sync(); len(changed) != 0 || store.writes != 1 {
+		t.Fatalf("unchanged cluster should not be written again: %v", changed)
+	}
+
+	client.slices[0].Endpoints = client.slices[0].Endpoints[1:]
+	if changed, _ := s.Sync(); len(changed) != 1 || len(store.cds["order"].Endpoints) != 1 {
+		t.Fatalf("removed endpoint should be written: %v", changed)
+	}
+}
+
+func TestSync_Namespaces(t *testing.T) {
+	client := newFakeClient()
+	store := &fakeStore{cds: map[string]*envoy.EDS{
+		"manual": {Name: "manual", Endpoints: []*envoy.Endpoint{{Ip: "10.1.0.1", Port: 80}}},
+	}}
+
+	other := client.services[0]
+	other.Namespace = "test"
+	client.services = append(client.services, other)
+
+	s := NewSynchronizer(client, store, "", "")
+
+	if changed, err := s.Sync(); err == nil || len(changed) != 0 || store.cds["order"] != nil {
+		t.Fatalf("same-named services without an owner should not be synced: %v %v", changed, err)
+	}
+
+	store.cds["order"] = &envoy.EDS{Name: "order", Namespace: "shop"}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		if eds := store.cds["order"]; eds.Namespace != "shop" || len(eds.Endpoints) != 2 {
+			t.Fatalf("owner namespace should be kept: %s", util.StructToJson(eds))
+		}
+	}
+	if store.writes != 1 {
+		t.Fatalf("node should not flap between namespaces, %d writes", store.writes)
+	}
+
+	client.services = nil
+	if changed, err := s.Sync(); err != nil || len(changed) != 1 || changed[0] != "order" {
+		t.Fatalf("removed service should be deleted: %v %v", changed, err)
+	}
+	if store.cds["order"] != nil || store.cds["manual"] == nil {
+		t.Fatal("only nodes owned by the synchronizer should be deleted")
+	}
+}
+
+func TestSync_NoEndpoints(t *testing.T) {
+	client := newFakeClient()
+	client.slices = nil
+	store := &fakeStore{cds: map[string]*envoy.EDS{}}
+
+	s := NewSynchronizer(client, store, "shop", "")
+
+	if changed, err := s.Sync(); err != nil || len(changed) != 1 || store.writes != 1 {
+		t.Fatalf("unexpected sync result %v %v", changed, err)
+	}
+	if changed, _ := s.Sync(); len(changed) != 0 || store.writes != 1 {
+		t.Fatalf("unchanged cluster should not be written again: %v", changed)
+	}
+
+	client.services[0].Spec.ClusterIP = "10.96.0.11"
+	client.services[0].Spec.Ports[0].Port = 81
+	if changed, _ := s.Sync(); len(changed) != 1 {
+		t.Fatalf("service change without endpoints should be written: %v", changed)
+	}
+	if eds := store.cds["order"]; eds.ClusterIp != "10.96.0.11" || eds.Ports[0].Port != 81 {
+		t.Fatalf("unexpected service fields %s", util.StructToJson(eds))
+	}
+}
@@ -0,0 +1,439 @@
+package k8s
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+var ErrNothingToRender = errors.New("nothing to render")
+
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// Workload 一个服务的部署描述，Render按Kind生成对应的Kubernetes对象
+type Workload struct {
+	Kind      envoy.K8sKind
+	Name      string //对应k8s中的service name
+	Namespace string
+	Image     string
+	Version   string //写入pod的version标签，与Synchronizer的versionLabel一致
+	Replicas  int32  //0时按Kind取默认值
+	Ports     []*envoy.Ports
+	Env       []EnvVar
+	Command   []string
+	Args      []string
+
+	Schedule        string //K8sKind_TimeJob的cron表达式
+	HealthCheckPath string //不为空时在第一个端口上配置HTTP就绪和存活探针
+
+	CPU         string //requests，为空时按Kind取默认值
+	Memory      string
+	CPULimit    string //limits，为空时不限制
+	MemoryLimit string
+
+	SidecarImage string //K8sKind_Expanded的sidecar镜像，为空时不注入
+}
+
+// kindDefaults 各Kind的默认值，与流水线中原先手写的模板一致
+type kindDefaults struct {
+	replicas    int32
+	cpu         string
+	memory      string
+	serviceType corev1.ServiceType
+	defaultPort int
+}
+
+var defaults = map[envoy.K8sKind]kindDefaults{
+	envoy.K8sKind_Expanded:   {replicas: 2, cpu: "200m", memory: "512Mi", serviceType: corev1.ServiceTypeClusterIP},
+	envoy.K8sKind_TimeJob:    {cpu: "100m", memory: "256Mi"},
+	envoy.K8sKind_JobWithEnv: {cpu: "100m", memory: "256Mi"},
+	envoy.K8sKind_Fronted:    {replicas: 2, cpu: "50m", memory: "64Mi", serviceType: corev1.ServiceTypeClusterIP, defaultPort: 80},
+	envoy.K8sKind_Job:        {cpu: "100m", memory: "256Mi"},
+	envoy.K8sKind_Gateway:    {replicas: 3, cpu: "500m", memory: "512Mi", serviceType: corev1.ServiceTypeNodePort},
+}
+
+func (w *Workload) Validate() error {
+	if w.Kind == envoy.K8sKind_Image {
+		return nil
+	}
+	if _, ok := defaults[w.Kind]; !ok {
+		return fmt.Errorf("unsupported kind %d", w.Kind)
+	}
+	if w.Name == "" || w.Image == "" {
+		return errors.New("name and image are required")
+	}
+
+	switch w.Kind {
+	case envoy.K8sKind_TimeJob:
+		if w.Schedule == "" {
+			return errors.New("schedule is required for time job")
+		}
+	case envoy.K8sKind_JobWithEnv:
+		if len(w.Env) == 0 {
+			return errors.New("env is required for job with env")
+		}
+	}
+
+	return nil
+}
+
+func (w *Workload) labels() map[string]string {
+	labels := map[string]string{"app": w.Name}
+	if w.Version != "" {
+		labels[defaultVersionLabel] = w.Version
+	}
+	return labels
+}
+
+// objectName 带版本的Deployment/Job名称，同一服务的多个版本可以同时存在
+func (w *Workload) objectName() string {
+	if w.Version == "" {
+		return w.Name
+	}
+	return w.Name + "-" + w.Version
+}
+
+func (w *Workload) objectMeta(name string, labels map[string]string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: w.Namespace, Labels: labels}
+}
+
+func (w *Workload) ports() []*envoy.Ports {
+	if len(w.Ports) > 0 || defaults[w.Kind].defaultPort == 0 {
+		return w.Ports
+	}
+
+	port := defaults[w.Kind].defaultPort
+	return []*envoy.Ports{{Name: w.Name + "-http", Port: port, TargetPort: port}}
+}
+
+func targetPort(p *envoy.Ports) int {
+	if p.TargetPort > 0 {
+		return p.TargetPort
+	}
+	return p.Port
+}
+
+func (w *Workload) resources() (corev1.ResourceRequirements, error) {
+	d := defaults[w.Kind]
+	cpu, memory := w.CPU, w.Memory
+	if cpu == "" {
+		cpu = d.cpu
+	}
+	if memory == "" {
+		memory = d.memory
+	}
+
+	parse := func(list corev1.ResourceList, name corev1.ResourceName, value string) error {
+		if value == "" {
+			return nil
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("%s %q: %v", name, value, err)
+		}
+		list[name] = q
+		return nil
+	}
+
+	req := corev1.ResourceRequirements{Requests: corev1.ResourceList{}}
+	if err := parse(req.Requests, corev1.ResourceCPU, cpu); err != nil {
+		return req, err
+	}
+	if err := parse(req.Requests, corev1.ResourceMemory, memory); err != nil {
+		return req, err
+	}
+
+	if w.CPULimit != "" || w.MemoryLimit != "" {
+		req.Limits = corev1.ResourceList{}
+		if err := parse(req.Limits, corev1.ResourceCPU, w.CPULimit); err != nil {
+			return req, err
+		}
+		if err := parse(req.Limits, corev1.ResourceMemory, w.MemoryLimit); err != nil {
+			return req, err
+		}
+	}
+
+	return req, nil
+}
+
+func (w *Workload) container() (corev1.Container, error) {
+	resources, err := w.resources()
+	if err != nil {
+		return corev1.Container{}, err
+	}
+
+	c := corev1.Container{
+		Name:            w.Name,
+		Image:           w.Image,
+		Command:         w.Command,
+		Args:            w.Args,
+		Resources:       resources,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+	}
+
+	for _, env := range w.Env {
+		c.Env = append(c.Env, corev1.EnvVar{Name: env.Name, Value: env.Value})
+	}
+
+	ports := w.ports()
+	for _, p := range ports {
+		if p == nil {
+			continue
+		}
+		c.Ports = append(c.Ports, corev1.ContainerPort{
+			Name:          portName(p),
+			ContainerPort: int32(targetPort(p)),
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+
+	if w.HealthCheckPath != "" && len(c.Ports) > 0 {
+		handler := corev1.Handler{HTTPGet: &corev1.HTTPGetAction{
+			Path: w.HealthCheckPath,
+			Port: intstr.FromInt(int(c.Ports[0].ContainerPort)),
+		}}
+		c.ReadinessProbe = &corev1.Probe{Handler: handler, PeriodSeconds: 10, FailureThreshold: 3}
+		c.LivenessProbe = &corev1.Probe{Handler: handler, InitialDelaySeconds: 30, PeriodSeconds: 10, FailureThreshold: 3}
+	}
+
+	return c, nil
+}
+
+// portName 容器端口名最长15个字符，超出时按端口号命名
+func portName(p *envoy.Ports) string {
+	if p.Name != "" && len(p.Name) <= 15 {
+		return p.Name
+	}
+	return "port-" + strconv.Itoa(targetPort(p))
+}
+
+func (w *Workload) podTemplate(restartPolicy corev1.RestartPolicy) (corev1.PodTemplateSpec, error) {
+	c, err := w.container()
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: w.labels()},
+		Spec: corev1.PodSpec{
+			Containers:    []corev1.Container{c},
+			RestartPolicy: restartPolicy,
+		},
+	}
+
+	if w.Kind == envoy.K8sKind_Expanded && w.SidecarImage != "" {
+		template.Spec.Containers = append(template.Spec.Containers, corev1.Container{
+			Name:            "sidecar",
+			Image:           w.SidecarImage,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+		})
+	}
+
+	return template, nil
+}
+
+func (w *Workload) deployment() (*appsv1.Deployment, error) {
+	template, err := w.podTemplate(corev1.RestartPolicyAlways)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := w.Replicas
+	if replicas <= 0 {
+		replicas = defaults[w.Kind].replicas
+	}
+	revisionHistoryLimit := int32(5)
+	maxUnavailable := intstr.FromInt(0)
+	maxSurge := intstr.FromString("25%")
+
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: w.objectMeta(w.objectName(), w.labels()),
+		Spec: appsv1.DeploymentSpec{
+			Replicas:             &replicas,
+			RevisionHistoryLimit: &revisionHistoryLimit,
+			Selector:             &metav1.LabelSelector{MatchLabels: w.labels()},
+			Strategy: appsv1.DeploymentStrategy{
+				Type:          appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{MaxUnavailable: &maxUnavailable, MaxSurge: &maxSurge},
+			},
+			Template: template,
+		},
+	}, nil
+}
+
+// service 选择同一服务的所有版本，版本间的流量由EDS_Version控制
+func (w *Workload) service() *corev1.Service {
+	ports := w.ports()
+	if len(ports) == 0 {
+		return nil
+	}
+
+	svc := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: w.objectMeta(w.Name, map[string]string{"app": w.Name}),
+		Spec: corev1.ServiceSpec{
+			Type:     defaults[w.Kind].serviceType,
+			Selector: map[string]string{"app": w.Name},
+		},
+	}
+
+	for _, p := range ports {
+		if p == nil {
+			continue
+		}
+
+		port := corev1.ServicePort{
+			Name:       p.Name,
+			Protocol:   corev1.ProtocolTCP,
+			Port:       int32(p.Port),
+			TargetPort: intstr.FromInt(targetPort(p)),
+		}
+		if p.AppProtocol != "" {
+			appProtocol := p.AppProtocol
+			port.AppProtocol = &appProtocol
+		}
+		if svc.Spec.Type == corev1.ServiceTypeNodePort {
+			port.NodePort = int32(p.NodePort)
+		}
+		svc.Spec.Ports = append(svc.Spec.Ports, port)
+	}
+
+	return svc
+}
+
+func (w *Workload) jobSpec() (batchv1.JobSpec, error) {
+	restartPolicy := corev1.RestartPolicyOnFailure
+	backoffLimit := int32(3)
+	if w.Kind == envoy.K8sKind_JobWithEnv {
+		restartPolicy = corev1.RestartPolicyNever
+		backoffLimit = 0
+	}
+
+	template, err := w.podTemplate(restartPolicy)
+	if err != nil {
+		return batchv1.JobSpec{}, err
+	}
+
+	ttl := int32(3600)
+	return batchv1.JobSpec{
+		BackoffLimit:            &backoffLimit,
+		TTLSecondsAfterFinished: &ttl,
+		Template:                template,
+	}, nil
+}
+
+func (w *Workload) job() (*batchv1.Job, error) {
+	spec, err := w.jobSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	return &batchv1.Job{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: w.objectMeta(w.objectName(), w.labels()),
+		Spec:       spec,
+	}, nil
+}
+
+func (w *Workload) cronJob() (*batchv1.CronJob, error) {
+	spec, err := w.jobSpec()
+	if err != nil {
+		return nil, err
+	}
+	// CronJob创建的Job由历史记录数控制清理
+	spec.TTLSecondsAfterFinished = nil
+
+	successfulJobsHistoryLimit := int32(3)
+	failedJobsHistoryLimit := int32(1)
+
+	return &batchv1.CronJob{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+		ObjectMeta: w.objectMeta(w.objectName(), w.labels()),
+		Spec: batchv1.CronJobSpec{
+			Schedule:                   w.Schedule,
+			ConcurrencyPolicy:          batchv1.ForbidConcurrent,
+			SuccessfulJobsHistoryLimit: &successfulJobsHistoryLimit,
+			FailedJobsHistoryLimit:     &failedJobsHistoryLimit,
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: w.labels()},
+				Spec:       spec,
+			},
+		},
+	}, nil
+}
+
+// Render 按Kind生成Kubernetes对象：
+// 可扩展部署、前端、网关生成Deployment和Service(没有端口时不生成Service)，定时任务生成CronJob，
+// Job和带环境变量的Job生成Job，镜像只构建不部署，返回ErrNothingToRender
+func Render(w *Workload) ([]runtime.Object, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch w.Kind {
+	case envoy.K8sKind_Image:
+		return nil, ErrNothingToRender
+
+	case envoy.K8sKind_Expanded, envoy.K8sKind_Fronted, envoy.K8sKind_Gateway:
+		deployment, err := w.deployment()
+		if err != nil {
+			return nil, err
+		}
+		objects := []runtime.Object{deployment}
+		if svc := w.service(); svc != nil {
+			objects = append(objects, svc)
+		}
+		return objects, nil
+
+	case envoy.K8sKind_TimeJob:
+		cronJob, err := w.cronJob()
+		if err != nil {
+			return nil, err
+		}
+		return []runtime.Object{cronJob}, nil
+
+	default:
+		job, err := w.job()
+		if err != nil {
+			return nil, err
+		}
+		return []runtime.Object{job}, nil
+	}
+}
+
+// RenderYAML Render的结果以---分隔输出为YAML
+func RenderYAML(w *Workload) ([]byte, error) {
+	objects, err := Render(w)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for i, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), nil
+}
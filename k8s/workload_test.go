@@ -0,0 +1,94 @@
+package k8s
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRender_Deployment(t *testing.T) {
+	w := &Workload{
+		Kind:            envoy.K8sKind_Gateway,
+		Name:            "gateway",
+		Namespace:       "prod",
+		Image:           "registry/gateway:1.0",
+		Version:         "v2",
+		Ports:           []*envoy.Ports{{Name: "gateway-http", Port: 80, TargetPort: 8080, NodePort: 30080}},
+		HealthCheckPath: "/health",
+	}
+
+	objects, err := Render(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("expected deployment and service, got %d objects", len(objects))
+	}
+
+	deployment := objects[0].(*appsv1.Deployment)
+	if deployment.Name != "gateway-v2" || *deployment.Spec.Replicas != 3 || deployment.Spec.Selector.MatchLabels["version"] != "v2" {
+		t.Fatalf("unexpected deployment %+v", deployment.ObjectMeta)
+	}
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if container.Ports[0].ContainerPort != 8080 || container.ReadinessProbe.HTTPGet.Path != "/health" {
+		t.Fatalf("unexpected container %+v", container)
+	}
+	if cpu := container.Resources.Requests[corev1.ResourceCPU]; cpu.String() != "500m" {
+		t.Fatalf("unexpected cpu request %s", cpu.String())
+	}
+
+	svc := objects[1].(*corev1.Service)
+	if svc.Name != "gateway" || svc.Spec.Type != corev1.ServiceTypeNodePort || svc.Spec.Ports[0].NodePort != 30080 || svc.Spec.Selector["version"] != "" {
+		t.Fatalf("unexpected service %+v", svc.Spec)
+	}
+}
+
+func TestRender_Jobs(t *testing.T) {
+	cron := &Workload{Kind: envoy.K8sKind_TimeJob, Name: "report", Image: "registry/report:1.0"}
+	if _, err := Render(cron); err == nil {
+		t.Fatal("expected error without schedule")
+	}
+
+	cron.Schedule = "0 2 * * *"
+	objects, err := Render(cron)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cronJob := objects[0].(*batchv1.CronJob)
+	if cronJob.Spec.ConcurrencyPolicy != batchv1.ForbidConcurrent || cronJob.Spec.JobTemplate.Spec.Template.Spec.RestartPolicy != corev1.RestartPolicyOnFailure {
+		t.Fatalf("unexpected cron job %+v", cronJob.Spec)
+	}
+
+	envJob := &Workload{Kind: envoy.K8sKind_JobWithEnv, Name: "migrate", Image: "registry/migrate:1.0", Env: []EnvVar{{Name: "ENV", Value: "prod"}}}
+	objects, err = Render(envJob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := objects[0].(*batchv1.Job)
+	if *job.Spec.BackoffLimit != 0 || job.Spec.Template.Spec.Containers[0].Env[0].Value != "prod" {
+		t.Fatalf("unexpected job %+v", job.Spec)
+	}
+
+	if _, err := Render(&Workload{Kind: envoy.K8sKind_Image, Name: "base"}); err != ErrNothingToRender {
+		t.Fatalf("expected ErrNothingToRender, got %v", err)
+	}
+}
+
+func TestRenderYAML(t *testing.T) {
+	data, err := RenderYAML(&Workload{Kind: envoy.K8sKind_Fronted, Name: "web", Image: "registry/web:1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := strings.Split(string(data), "---\n")
+	if len(docs) != 2 || !strings.Contains(docs[0], "kind: Deployment") || !strings.Contains(docs[1], "kind: Service") {
+		t.Fatalf("unexpected yaml:\n%s", data)
+	}
+	if !strings.Contains(docs[1], "port: 80") {
+		t.Fatalf("frontend should expose port 80 by default:\n%s", docs[1])
+	}
+}
@@ -0,0 +1,145 @@
+package limiter
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+// Decision 限速判定结果，Action为触发拒绝的规则
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	Action     *envoy.LimitAction
+}
+
+type Options struct {
+	// TrustedProxies 部署在前面的可信代理层数，按客户端ip限速时用于从X-Forwarded-For中取地址，0表示使用RemoteAddr
+	TrustedProxies int
+}
+
+type Limiter struct {
+	store Store
+	opts  Options
+}
+
+// New store为空时使用内存令牌桶
+func New(store Store, opts Options) *Limiter {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	return &Limiter{store: store, opts: opts}
+}
+
+// Allow 路由上所有启用的限速规则都有余量时才放行并各消耗一次，任一规则超限即拒绝且不消耗其它规则的令牌
+// RetryAfter取各超限规则中最长的等待时间，存储出错时放行，避免限速组件故障导致整个路由不可用
+func (l *Limiter) Allow(r *http.Request, route *envoy.HTTPRoute) Decision {
+	decision := Decision{Allowed: true}
+
+	if route == nil || route.RateLimits == nil || !route.RateLimits.IsEnable {
+		return decision
+	}
+
+	var actions []*envoy.LimitAction
+	var quotas []Quota
+
+	for _, action := range route.RateLimits.LimitActions {
+		if action == nil || !action.IsEnable {
+			continue
+		}
+
+		key := l.limitKey(r, route, action)
+		if key == "" {
+			continue
+		}
+
+		actions = append(actions, action)
+		quotas = append(quotas, Quota{Key: key, Limit: action.Threshold, Window: action.Unit.Duration()})
+	}
+
+	if len(quotas) == 0 {
+		return decision
+	}
+
+	retryAfter, err := l.store.Take(quotas)
+	if err != nil {
+		log.Printf("limiter: take %s failed: %v\n", route.Prefix, err)
+		return decision
+	}
+
+	for i, wait := range retryAfter {
+		if wait > 0 && (decision.Allowed || wait > decision.RetryAfter) {
+			decision = Decision{
+				Allowed:    false,
+				RetryAfter: wait,
+				Action:     actions[i],
+			}
+		}
+	}
+
+	return decision
+}
+
+// limitKey 除按集群限速外，都以host+路由前缀为范围，不同域名下相同前缀的路由互不影响
+func (l *Limiter) limitKey(r *http.Request, route *envoy.HTTPRoute, action *envoy.LimitAction) string {
+	scope := requestHost(r) + route.Prefix
+
+	var value string
+
+	switch action.Type {
+	case envoy.Limit_Route:
+		value = scope
+	case envoy.Limit_Cluster:
+		value = route.ClusterName
+	case envoy.Limit_ClientIp:
+		value = scope + "|" + util.GetClientIp(r, l.opts.TrustedProxies)
+	case envoy.Limit_Guid:
+		guid := r.Header.Get(route.GetGuIdKey())
+		if guid == "" {
+			guid = util.GetUrlQueryStringByLastOne(route.GetGuIdKey(), r.URL.Query())
+		}
+		if guid == "" {
+			return ""
+		}
+		value = scope + "|" + guid
+	default:
+		return ""
+	}
+
+	return fmt.Sprintf("%d:%d:%d:%s", action.Type, action.Unit, action.Threshold, value)
+}
+
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+type RouteResolver func(r *http.Request) *envoy.HTTPRoute
+
+// Middleware 超限时返回429并设置Retry-After(秒)
+func (l *Limiter) Middleware(resolve RouteResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decision := l.Allow(r, resolve(r))
+
+		if !decision.Allowed {
+			seconds := int64(math.Ceil(decision.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
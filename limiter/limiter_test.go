@@ -0,0 +1,129 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(newMemoryStore(func() time.Time { return now }), Options{TrustedProxies: 1})
+
+	route := &envoy.HTTPRoute{
+		Prefix:      "/order/",
+		ClusterName: "order",
+		RateLimits: &envoy.RateLimit{
+			IsEnable: true,
+			LimitActions: []*envoy.LimitAction{
+				{IsEnable: true, Type: envoy.Limit_ClientIp, Threshold: 2, Unit: envoy.Unit_Second},
+				{IsEnable: false, Type: envoy.Limit_Route, Threshold: 0, Unit: envoy.Unit_Second},
+			},
+		},
+	}
+
+	request := func(ip string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/order/1", nil)
+		r.Header.Set("X-Forwarded-For", ip)
+		return r
+	}
+
+	for i := 0; i < 2; i++ {
+		if d := l.Allow(request("1.1.1.1"), route); !d.Allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+
+	d := l.Allow(request("1.1.1.1"), route)
+	if d.Allowed || d.RetryAfter <= 0 || d.Action != route.RateLimits.LimitActions[0] {
+		t.Fatalf("third request should be denied, got %+v", d)
+	}
+
+	if d := l.Allow(request("2.2.2.2"), route); !d.Allowed {
+		t.Fatal("other client ip should be allowed")
+	}
+
+	if d := l.Allow(request("9.9.9.9, 1.1.1.1"), route); d.Allowed {
+		t.Fatal("spoofed leftmost X-Forwarded-For should not bypass the limit")
+	}
+
+	now = now.Add(d.RetryAfter)
+	if d := l.Allow(request("1.1.1.1"), route); !d.Allowed {
+		t.Fatal("request should be allowed after retry-after")
+	}
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	route := &envoy.HTTPRoute{
+		Prefix: "/",
+		RateLimits: &envoy.RateLimit{
+			IsEnable:     true,
+			LimitActions: []*envoy.LimitAction{{IsEnable: true, Type: envoy.Limit_Guid, Threshold: 1, Unit: envoy.Unit_Minute}},
+		},
+	}
+
+	handler := New(nil, Options{}).Middleware(func(r *http.Request) *envoy.HTTPRoute { return route },
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	codes := make([]int, 0)
+	for _, url := range []string{"/?guid=a", "/?guid=a", "/?guid=b", "/"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		codes = append(codes, rec.Code)
+	}
+
+	expected := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK, http.StatusOK}
+	for i := range expected {
+		if codes[i] != expected[i] {
+			t.Fatalf("unexpected status codes %v", codes)
+		}
+	}
+}
+
+func TestLimiter_AllowScope(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(newMemoryStore(func() time.Time { return now }), Options{})
+
+	route := &envoy.HTTPRoute{
+		Prefix: "/",
+		RateLimits: &envoy.RateLimit{
+			IsEnable: true,
+			LimitActions: []*envoy.LimitAction{
+				{IsEnable: true, Type: envoy.Limit_Route, Threshold: 2, Unit: envoy.Unit_Minute},
+				{IsEnable: true, Type: envoy.Limit_ClientIp, Threshold: 1, Unit: envoy.Unit_Minute},
+			},
+		},
+	}
+
+	request := func(host string, remote string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		r.RemoteAddr = remote + ":1234"
+		r.Header.Set("X-Forwarded-For", "7.7.7.7")
+		return r
+	}
+
+	if d := l.Allow(request("a.com", "1.1.1.1"), route); !d.Allowed {
+		t.Fatal("first request should be allowed")
+	}
+
+	//X-Forwarded-For不可信，同一个RemoteAddr仍然命中ip限速；被拒绝的请求不消耗路由的令牌
+	d := l.Allow(request("a.com", "1.1.1.1"), route)
+	if d.Allowed || d.Action != route.RateLimits.LimitActions[1] {
+		t.Fatalf("second request from the same ip should be denied by ip limit, got %+v", d)
+	}
+
+	if d := l.Allow(request("a.com", "2.2.2.2"), route); !d.Allowed {
+		t.Fatal("route limit should not be consumed by denied requests")
+	}
+
+	if d := l.Allow(request("b.com:8080", "3.3.3.3"), route); !d.Allowed {
+		t.Fatal("same prefix on another host should use its own bucket")
+	}
+
+	if d := l.Allow(request("a.com", "3.3.3.3"), route); d.Allowed || d.Action != route.RateLimits.LimitActions[0] {
+		t.Fatalf("route limit of a.com should be exhausted, got %+v", d)
+	}
+}
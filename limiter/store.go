@@ -0,0 +1,109 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Quota 一条限速规则对应的令牌桶，容量为Limit，每Window补满一次
+type Quota struct {
+	Key    string
+	Limit  int
+	Window time.Duration
+}
+
+// Store 限速计数的存储，单实例使用内存令牌桶，多实例部署时可基于redis等共享存储实现
+type Store interface {
+	// Take 所有令牌桶都有令牌时各取一个，任一桶不足时不消耗任何令牌
+	// retryAfter与quotas一一对应，有令牌的桶为0
+	Take(quotas []Quota) (retryAfter []time.Duration, err error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	window time.Duration
+}
+
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() Store {
+	return newMemoryStore(time.Now)
+}
+
+func newMemoryStore(now func() time.Time) *memoryStore {
+	return &memoryStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: now(),
+		now:       now,
+	}
+}
+
+func (s *memoryStore) Take(quotas []Quota) ([]time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	retryAfter := make([]time.Duration, len(quotas))
+	buckets := make([]*bucket, len(quotas))
+	need := make(map[*bucket]float64, len(quotas))
+	allowed := true
+
+	for i, q := range quotas {
+		if q.Limit <= 0 {
+			retryAfter[i] = q.Window
+			allowed = false
+			continue
+		}
+
+		rate := float64(q.Limit) / float64(q.Window)
+
+		b := s.buckets[q.Key]
+		if b == nil {
+			b = &bucket{tokens: float64(q.Limit), last: now}
+			s.buckets[q.Key] = b
+		}
+		b.window = q.Window
+
+		b.tokens = math.Min(float64(q.Limit), b.tokens+float64(now.Sub(b.last))*rate)
+		b.last = now
+
+		buckets[i] = b
+		need[b]++
+
+		if b.tokens < need[b] {
+			retryAfter[i] = time.Duration(math.Ceil((need[b] - b.tokens) / rate))
+			allowed = false
+		}
+	}
+
+	if allowed {
+		for _, b := range buckets {
+			b.tokens--
+		}
+	}
+
+	return retryAfter, nil
+}
+
+// sweep 每分钟清理一次已经补满的桶，避免按ip/guid限速时桶无限增长
+func (s *memoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if now.Sub(b.last) > b.window {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package logs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/mgcicd/cicd-core/pb/v1"
+)
+
+type Level int
+
+const (
+	LevelTrace Level = iota
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR"}
+
+// String 与LogInfo.Level的取值一致
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return "OTHER"
+	}
+	return levelNames[l]
+}
+
+// Field 结构化字段，输出为key=value
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// TraceIdKey 该字段的值同时写入LogInfo.TraceId
+const TraceIdKey = "traceId"
+
+// Entry 一条日志，Logger为日志来源，对应iconsoleLogger的module和kafkaLogger的logger
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Logger  string
+	Message string
+	Fields  []Field
+}
+
+// Text 消息和字段拼接后的文本
+func (e *Entry) Text() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+
+	var b strings.Builder
+	b.WriteString(e.Message)
+	for _, f := range e.Fields {
+		b.WriteString(" ")
+		b.WriteString(f.Key)
+		b.WriteString("=")
+		b.WriteString(fmt.Sprint(f.Value))
+	}
+	return b.String()
+}
+
+// Sink 日志的输出目标，Write不应阻塞调用方太久
+type Sink interface {
+	Write(entry *Entry)
+}
+
+// Logger 统一的日志接口，With返回附带固定字段的Logger
+type Logger interface {
+	Trace(message string, fields ...Field)
+	Debug(message string, fields ...Field)
+	Info(message string, fields ...Field)
+	Warn(message string, fields ...Field)
+	Error(message string, fields ...Field)
+	With(fields ...Field) Logger
+}
+
+type logger struct {
+	name   string
+	sink   Sink
+	fields []Field
+}
+
+// New 以name为来源写入sink，需要同时输出到多个目标时使用Tee
+func New(name string, sink Sink) Logger {
+	return &logger{name: name, sink: sink}
+}
+
+func (l *logger) log(level Level, message string, fields []Field) {
+	all := fields
+	if len(l.fields) > 0 {
+		all = make([]Field, 0, len(l.fields)+len(fields))
+		all = append(all, l.fields...)
+		all = append(all, fields...)
+	}
+
+	l.sink.Write(&Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Logger:  l.name,
+		Message: message,
+		Fields:  all,
+	})
+}
+
+func (l *logger) Trace(message string, fields ...Field) {
+	l.log(LevelTrace, message, fields)
+}
+
+func (l *logger) Debug(message string, fields ...Field) {
+	l.log(LevelDebug, message, fields)
+}
+
+func (l *logger) Info(message string, fields ...Field) {
+	l.log(LevelInfo, message, fields)
+}
+
+func (l *logger) Warn(message string, fields ...Field) {
+	l.log(LevelWarn, message, fields)
+}
+
+func (l *logger) Error(message string, fields ...Field) {
+	l.log(LevelError, message, fields)
+}
+
+func (l *logger) With(fields ...Field) Logger {
+	all := make([]Field, 0, len(l.fields)+len(fields))
+	all = append(all, l.fields...)
+	all = append(all, fields...)
+
+	return &logger{name: l.name, sink: l.sink, fields: all}
+}
+
+type levelSink struct {
+	min  Level
+	sink Sink
+}
+
+func (s *levelSink) Write(entry *Entry) {
+	if entry.Level >= s.min {
+		s.sink.Write(entry)
+	}
+}
+
+// WithLevel 只把不低于min的日志写入sink
+func WithLevel(min Level, sink Sink) Sink {
+	return &levelSink{min: min, sink: sink}
+}
+
+type teeSink []Sink
+
+func (t teeSink) Write(entry *Entry) {
+	for _, sink := range t {
+		sink.Write(entry)
+	}
+}
+
+// Tee 把每条日志依次写入所有sink，各sink可用WithLevel设置自己的级别
+func Tee(sinks ...Sink) Sink {
+	return teeSink(sinks)
+}
+
+// ConsoleSink 与DefaultConsoleLog相同的格式输出到Writer
+type ConsoleSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewConsoleSink w为空时输出到标准输出
+func NewConsoleSink(w io.Writer) *ConsoleSink {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &ConsoleSink{w: w}
+}
+
+func (s *ConsoleSink) Write(entry *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(s.w, "%v : [%v] %v By %v\n", entry.Time.Format("2006-01-02 15:04:05"), entry.Level, entry.Text(), entry.Logger)
+}
+
+// KafkaSink 按级别写入monitor-*-log，LogType为消息的key
+type KafkaSink struct {
+	LogType string
+}
+
+// NewKafkaSink 与NewKafkaLogger共用同一个生产者
+func NewKafkaSink(logType string) *KafkaSink {
+	NewKafkaLogger()
+	return &KafkaSink{LogType: logType}
+}
+
+func (s *KafkaSink) Write(entry *Entry) {
+	_ = info0(toLogInfo(s.LogType, entry))
+}
+
+// toLogInfo 与kafkaLogger一致，ERROR级别的文本写入Exception，其余写入Message
+func toLogInfo(logType string, entry *Entry) *v1.LogInfo {
+	in := &v1.LogInfo{
+		Level:      entry.Level.String(),
+		LogType:    logType,
+		Logger:     entry.Logger,
+		CreateTime: entry.Time.Format(time.RFC3339),
+	}
+
+	if entry.Level == LevelError {
+		in.Exception = entry.Text()
+	} else {
+		in.Message = entry.Text()
+	}
+
+	for _, f := range entry.Fields {
+		if f.Key == TraceIdKey {
+			in.TraceId = fmt.Sprint(f.Value)
+		}
+	}
+
+	return in
+}
@@ -0,0 +1,56 @@
+package logs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type memorySink struct {
+	entries []*Entry
+}
+
+func (s *memorySink) Write(entry *Entry) {
+	s.entries = append(s.entries, entry)
+}
+
+func TestLogger_Tee(t *testing.T) {
+	var buf bytes.Buffer
+	all := &memorySink{}
+
+	logger := New("order", Tee(all, WithLevel(LevelWarn, NewConsoleSink(&buf))))
+	logger = logger.With(F("traceId", "t-1"))
+
+	logger.Debug("cache miss", F("key", "order:1"))
+	logger.Error("create order failed", F("userId", 1001))
+
+	if len(all.entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(all.entries))
+	}
+	if text := all.entries[0].Text(); text != "cache miss traceId=t-1 key=order:1" {
+		t.Fatalf("unexpected text %s", text)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "[ERROR] create order failed traceId=t-1 userId=1001 By order") {
+		t.Fatalf("unexpected console output %q", buf.String())
+	}
+}
+
+func TestToLogInfo(t *testing.T) {
+	sink := &memorySink{}
+	logger := New("order", sink)
+
+	logger.Info("created", F(TraceIdKey, "t-2"))
+	logger.Error("failed")
+
+	info := toLogInfo("MonitorApi", sink.entries[0])
+	if info.Level != "INFO" || info.Message != "created traceId=t-2" || info.TraceId != "t-2" || info.Logger != "order" || info.LogType != "MonitorApi" {
+		t.Fatalf("unexpected log info %+v", info)
+	}
+
+	info = toLogInfo("MonitorApi", sink.entries[1])
+	if info.Level != "ERROR" || info.Exception != "failed" || info.Message != "" {
+		t.Fatalf("unexpected log info %+v", info)
+	}
+}
@@ -0,0 +1,202 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+type Options struct {
+	// Client 为空时使用超时为Timeout的http.Client
+	Client *http.Client
+	// Workers 并发发送影子请求的协程数
+	Workers int
+	// QueueSize 待发送的影子请求队列长度，队列满时丢弃
+	QueueSize int
+	// MaxBodySize 请求体超过该大小时不做镜像，避免缓存大请求体
+	MaxBodySize int64
+	Timeout     time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 1024
+	}
+	if o.MaxBodySize <= 0 {
+		o.MaxBodySize = 1 << 20
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: o.Timeout}
+	}
+	return o
+}
+
+// Mirror 把请求按MirrorPolicy的比例复制到影子集群，影子请求在后台协程中发送，
+// 不等待也不影响主请求的响应
+type Mirror struct {
+	opts    Options
+	queue   chan *http.Request
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+	sent    uint64
+	dropped uint64
+}
+
+func NewMirror(opts Options) *Mirror {
+	opts = opts.withDefaults()
+
+	m := &Mirror{
+		opts:  opts,
+		queue: make(chan *http.Request, opts.QueueSize),
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		m.wg.Add(1)
+		go m.work()
+	}
+
+	return m
+}
+
+func (m *Mirror) work() {
+	defer m.wg.Done()
+
+	for req := range m.queue {
+		resp, err := m.opts.Client.Do(req)
+		if err != nil {
+			log.Printf("mirror: %s %s failed: %v\n", req.Method, req.URL.String(), err)
+			continue
+		}
+
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		atomic.AddUint64(&m.sent, 1)
+	}
+}
+
+// Close 停止接收新的影子请求，等待队列中的请求发送完成，之后的Send都返回false
+func (m *Mirror) Close() {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+}
+
+// Sent 已完成的影子请求数
+func (m *Mirror) Sent() uint64 {
+	return atomic.LoadUint64(&m.sent)
+}
+
+// Dropped 因队列已满、请求体过大或没有可用endpoint而放弃的影子请求数
+func (m *Mirror) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
+// Send 按比例抽样后把r复制一份发送到shadow集群，path为转发到上游的路径
+// 需要读取请求体时会把r.Body替换为可重复读取的副本，调用后主请求仍可正常转发
+func (m *Mirror) Send(policy *envoy.MirrorPolicy, shadow *envoy.EDS, r *http.Request, path string) bool {
+	if policy == nil || shadow == nil || rand.Float64()*100 >= policy.Percentage {
+		return false
+	}
+
+	body, ok := m.copyBody(r)
+	if !ok {
+		atomic.AddUint64(&m.dropped, 1)
+		return false
+	}
+
+	endpoint, err := shadow.GetEndpoint()
+	if err != nil {
+		atomic.AddUint64(&m.dropped, 1)
+		return false
+	}
+
+	target, err := url.Parse(endpoint.ToString())
+	if err != nil {
+		atomic.AddUint64(&m.dropped, 1)
+		return false
+	}
+
+	req := r.Clone(context.Background())
+	req.RequestURI = ""
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path = path
+	req.URL.RawPath = ""
+	req.Host = r.Host + "-shadow"
+	req.Header.Set(policy.GetHeader(), "1")
+	req.Body = nil
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+
+	return m.enqueue(req)
+}
+
+func (m *Mirror) enqueue(req *http.Request) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		atomic.AddUint64(&m.dropped, 1)
+		return false
+	}
+
+	select {
+	case m.queue <- req:
+		return true
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+		return false
+	}
+}
+
+// copyBody 读取请求体并替换r.Body，请求体超过MaxBodySize时还原r.Body并返回false
+func (m *Mirror) copyBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if r.ContentLength > m.opts.MaxBodySize {
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, m.opts.MaxBodySize+1))
+
+	if err != nil || int64(len(body)) > m.opts.MaxBodySize {
+		r.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+		return nil, false
+	}
+
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
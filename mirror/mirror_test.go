@@ -0,0 +1,94 @@
+package mirror
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+type shadowRequest struct {
+	host   string
+	path   string
+	header string
+	body   string
+}
+
+func newShadow(t *testing.T) (*envoy.EDS, chan shadowRequest) {
+	received := make(chan shadowRequest, 8)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- shadowRequest{host: r.Host, path: r.URL.Path, header: r.Header.Get(envoy.DefaultMirrorHeader), body: string(body)}
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	return &envoy.EDS{Name: "order-shadow", Endpoints: []*envoy.Endpoint{{Ip: host, Port: p}}}, received
+}
+
+func TestMirror_Send(t *testing.T) {
+	shadow, received := newShadow(t)
+
+	m := NewMirror(Options{MaxBodySize: 16})
+	defer m.Close()
+
+	policy := &envoy.MirrorPolicy{ClusterName: "order-shadow", Percentage: 100}
+
+	r := httptest.NewRequest(http.MethodPost, "http://api.example.com/order/1", strings.NewReader("payload"))
+	if !m.Send(policy, shadow, r, "/detail/1") {
+		t.Fatal("request should be mirrored")
+	}
+
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != "payload" {
+		t.Fatalf("primary body should be kept, got %q", body)
+	}
+
+	select {
+	case req := <-received:
+		if req.host != "api.example.com-shadow" || req.path != "/detail/1" || req.header != "1" || req.body != "payload" {
+			t.Fatalf("unexpected shadow request %+v", req)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow request was not sent")
+	}
+
+	large := httptest.NewRequest(http.MethodPost, "http://api.example.com/order/1", strings.NewReader(strings.Repeat("x", 32)))
+	large.ContentLength = -1
+	if m.Send(policy, shadow, large, "/detail/1") {
+		t.Fatal("large body should not be mirrored")
+	}
+	if body, _ := ioutil.ReadAll(large.Body); len(body) != 32 {
+		t.Fatalf("primary body should be kept, got %d bytes", len(body))
+	}
+
+	if m.Send(&envoy.MirrorPolicy{ClusterName: "order-shadow"}, shadow, r, "/detail/1") {
+		t.Fatal("zero percentage should not be mirrored")
+	}
+	if m.Dropped() != 1 {
+		t.Fatalf("unexpected dropped count %d", m.Dropped())
+	}
+}
+
+func TestMirror_SendAfterClose(t *testing.T) {
+	shadow, _ := newShadow(t)
+	m := NewMirror(Options{})
+	m.Close()
+	m.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/order/1", nil)
+	if m.Send(&envoy.MirrorPolicy{Percentage: 100}, shadow, r, "/order/1") {
+		t.Fatal("send after close should return false")
+	}
+	if m.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped request, got %d", m.Dropped())
+	}
+}
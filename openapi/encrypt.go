@@ -0,0 +1,428 @@
+package openapi
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/plugin"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+// HeaderKeyId 加密内容使用的密钥Id，请求和响应中出现该头表示body已加密
+const HeaderKeyId = "X-Encrypt-Key-Id"
+
+var (
+	ErrNoEncryptKey  = errors.New("no encrypt key")
+	ErrUnknownKeyId  = errors.New("unknown encrypt key id")
+	ErrInvalidCipher = errors.New("invalid encrypted content")
+)
+
+// EncryptKey 一个版本的内容加密密钥
+// 加密使用NotBefore最晚且已生效的密钥；解密接受[NotBefore, ExpireAt)内的任一密钥，
+// 轮换时新密钥生效后旧密钥在ExpireAt之前仍可解密，即重叠窗口
+type EncryptKey struct {
+	Id        string
+	Key       string //base64编码的16、24或32字节AES密钥
+	NotBefore int64  //生效时间(unix秒)，0表示立即生效
+	ExpireAt  int64  //失效时间(unix秒)，0表示不失效
+	Status    util.YesOrNo
+}
+
+func (k *EncryptKey) valid(now time.Time) bool {
+	if k == nil || k.Status == util.No {
+		return false
+	}
+	if k.NotBefore > 0 && now.Unix() < k.NotBefore {
+		return false
+	}
+	if k.ExpireAt > 0 && now.Unix() >= k.ExpireAt {
+		return false
+	}
+	return true
+}
+
+func (k *EncryptKey) aead() (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// ActiveKey 当前用于加密的密钥
+func ActiveKey(keys []*EncryptKey, now time.Time) *EncryptKey {
+	var active *EncryptKey
+	for _, key := range keys {
+		if key.valid(now) && (active == nil || key.NotBefore > active.NotBefore) {
+			active = key
+		}
+	}
+	return active
+}
+
+// FindKey 按Id查找当前可用于解密的密钥
+func FindKey(keys []*EncryptKey, id string, now time.Time) *EncryptKey {
+	for _, key := range keys {
+		if key.valid(now) && key.Id == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// Seal 输出base64(nonce || AES-GCM密文)，appId作为附加数据，防止密文在不同应用间挪用
+func Seal(key *EncryptKey, appId string, plaintext []byte) ([]byte, error) {
+	aead, err := key.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(appId))
+
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(out, sealed)
+	return out, nil
+}
+
+func Open(key *EncryptKey, appId string, data []byte) ([]byte, error) {
+	aead, err := key.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(sealed, bytes.TrimSpace(data))
+	if err != nil || n < aead.NonceSize() {
+		return nil, ErrInvalidCipher
+	}
+	sealed = sealed[:n]
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(appId))
+	if err != nil {
+		return nil, ErrInvalidCipher
+	}
+	return plaintext, nil
+}
+
+// EncryptKeyStore 按AppId查询内容加密密钥，ManagerKeyStore实现了该接口
+type EncryptKeyStore interface {
+	EncryptKeys(appId string) []*EncryptKey
+}
+
+// Encryptor IfNeedEncryptContent路由的服务端加解密：
+// 请求带HeaderKeyId时解密请求体，响应体加密后写入HeaderKeyId
+type Encryptor struct {
+	Keys EncryptKeyStore
+	// MaxBodySize 加解密时最多读取的请求体和响应体大小，为0时为1MB
+	MaxBodySize int64
+	// Now 为空时使用time.Now
+	Now func() time.Time
+}
+
+func (e *Encryptor) maxBodySize() int64 {
+	if e.MaxBodySize <= 0 {
+		return 1 << 20
+	}
+	return e.MaxBodySize
+}
+
+func (e *Encryptor) now() time.Time {
+	if e.Now != nil {
+		return e.Now()
+	}
+	return time.Now()
+}
+
+// DecryptRequest 应用没有可用密钥时返回ErrNoEncryptKey，避免请求到达上游后响应无法加密
+// 请求体不为空时必须带HeaderKeyId，否则返回ErrUnknownKeyId，不接受明文请求体；解密后把请求体替换为明文
+func (e *Encryptor) DecryptRequest(r *http.Request) error {
+	appId := r.Header.Get(HeaderAppId)
+	keys := e.Keys.EncryptKeys(appId)
+	if ActiveKey(keys, e.now()) == nil {
+		return ErrNoEncryptKey
+	}
+
+	body, err := readBody(r, e.maxBodySize())
+	if err != nil {
+		return err
+	}
+
+	id := r.Header.Get(HeaderKeyId)
+	if id == "" {
+		if len(body) > 0 {
+			return ErrUnknownKeyId
+		}
+		return nil
+	}
+
+	key := FindKey(keys, id, e.now())
+	if key == nil {
+		return ErrUnknownKeyId
+	}
+
+	plaintext, err := Open(key, appId, body)
+	if err != nil {
+		return err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(plaintext))
+	r.ContentLength = int64(len(plaintext))
+	r.Header.Del(HeaderKeyId)
+
+	return nil
+}
+
+// encryptBody 返回加密后的body并设置header
+// keyId为请求使用的密钥，仍然有效时响应使用同一密钥，保证轮换期间只持有旧密钥的调用方能解密
+func (e *Encryptor) encryptBody(appId string, keyId string, header http.Header, body []byte) ([]byte, error) {
+	keys := e.Keys.EncryptKeys(appId)
+
+	key := FindKey(keys, keyId, e.now())
+	if key == nil {
+		key = ActiveKey(keys, e.now())
+	}
+	if key == nil {
+		return nil, ErrNoEncryptKey
+	}
+
+	sealed, err := Seal(key, appId, body)
+	if err != nil {
+		return nil, err
+	}
+
+	header.Set(HeaderKeyId, key.Id)
+	header.Set("Content-Length", strconv.Itoa(len(sealed)))
+	return sealed, nil
+}
+
+// EncryptResponse 加密上游响应体，keyId为请求使用的密钥Id，没有时使用当前密钥
+func (e *Encryptor) EncryptResponse(appId string, keyId string, resp *http.Response) error {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, e.maxBodySize()+1))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > e.maxBodySize() {
+		return ErrBodyTooLarge
+	}
+
+	sealed, err := e.encryptBody(appId, keyId, resp.Header, body)
+	if err != nil {
+		return err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(sealed))
+	resp.ContentLength = int64(len(sealed))
+	return nil
+}
+
+func encryptStatus(err error) int {
+	switch err {
+	case ErrNoEncryptKey, ErrUnknownKeyId:
+		return http.StatusForbidden
+	case ErrBodyTooLarge:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Handler 只处理routeFor返回的路由开启了IfNeedEncryptContent的请求
+func (e *Encryptor) Handler(next http.Handler, routeFor func(r *http.Request) *envoy.HTTPRoute) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeFor(r)
+		if route == nil || !route.IfNeedEncryptContent {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keyId := r.Header.Get(HeaderKeyId)
+		if err := e.DecryptRequest(r); err != nil {
+			http.Error(w, err.Error(), encryptStatus(err))
+			return
+		}
+
+		buffered := &bufferedWriter{header: make(http.Header)}
+		next.ServeHTTP(buffered, r)
+
+		sealed, err := e.encryptBody(r.Header.Get(HeaderAppId), keyId, buffered.header, buffered.body.Bytes())
+		if err != nil {
+			http.Error(w, err.Error(), encryptStatus(err))
+			return
+		}
+
+		for k, v := range buffered.header {
+			w.Header()[k] = v
+		}
+		if buffered.status == 0 {
+			buffered.status = http.StatusOK
+		}
+		w.WriteHeader(buffered.status)
+		_, _ = w.Write(sealed)
+	})
+}
+
+// plugin.Context.Values中的key：请求使用的密钥Id，解密前的原始请求体(签名校验使用)
+const (
+	keyIdValue   = "openapi.keyId"
+	rawBodyValue = "openapi.rawBody"
+)
+
+type encryptPlugin struct {
+	encryptor *Encryptor
+}
+
+// OnRequest 调用方对密文签名，解密前保存原始请求体，PhaseAuth的签名校验按密文进行
+func (p *encryptPlugin) OnRequest(ctx *plugin.Context) error {
+	keyId := ctx.Request.Header.Get(HeaderKeyId)
+	raw, err := readBody(ctx.Request, p.encryptor.maxBodySize())
+	if err == nil {
+		err = p.encryptor.DecryptRequest(ctx.Request)
+	}
+	if err != nil {
+		return plugin.Reject(encryptStatus(err), err.Error())
+	}
+
+	if ctx.Values != nil {
+		ctx.Values[keyIdValue] = keyId
+		ctx.Values[rawBodyValue] = raw
+	}
+	return nil
+}
+
+func (p *encryptPlugin) OnResponse(ctx *plugin.Context) error {
+	keyId, _ := ctx.Values[keyIdValue].(string)
+	return p.encryptor.EncryptResponse(ctx.Request.Header.Get(HeaderAppId), keyId, ctx.Response)
+}
+
+// RegisterEncryptPlugin 以name注册内容加解密插件，只对开启了IfNeedEncryptContent的路由生效
+// 与RegisterPlugin注册的签名校验插件同时使用时，签名按解密前的密文校验
+func RegisterEncryptPlugin(name string, encryptor *Encryptor) {
+	plugin.Register(name, plugin.PhaseRequest|plugin.PhaseResponse, func(lds *envoy.LDS, route *envoy.HTTPRoute) (plugin.Plugin, error) {
+		if !route.IfNeedEncryptContent {
+			return nil, nil
+		}
+		return &encryptPlugin{encryptor: encryptor}, nil
+	})
+}
+
+// EncryptTransport 调用方使用：请求体使用当前密钥加密，响应带HeaderKeyId时解密响应体
+// 同时需要签名时签名应在加密之后，即Base为签名的Transport
+type EncryptTransport struct {
+	AppId string
+	Keys  []*EncryptKey
+	Base  http.RoundTripper
+	// Now 为空时使用time.Now
+	Now func() time.Time
+}
+
+func (t *EncryptTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	now := time.Now()
+	if t.Now != nil {
+		now = t.Now()
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Set(HeaderAppId, t.AppId)
+
+	body, err := readBody(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > 0 {
+		key := ActiveKey(t.Keys, now)
+		if key == nil {
+			return nil, ErrNoEncryptKey
+		}
+
+		sealed, err := Seal(key, t.AppId, body)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(sealed))
+		r.ContentLength = int64(len(sealed))
+		r.Header.Set(HeaderKeyId, key.Id)
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	id := resp.Header.Get(HeaderKeyId)
+	if id == "" {
+		return resp, nil
+	}
+
+	key := FindKey(t.Keys, id, now)
+	if key == nil {
+		resp.Body.Close()
+		return nil, ErrUnknownKeyId
+	}
+
+	sealed, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := Open(key, t.AppId, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(plaintext))
+	resp.ContentLength = int64(len(plaintext))
+	resp.Header.Del(HeaderKeyId)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(plaintext)))
+
+	return resp, nil
+}
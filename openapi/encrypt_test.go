@@ -0,0 +1,239 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/plugin"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func newKey(id string, notBefore time.Time, expireAt time.Time) *EncryptKey {
+	key := &EncryptKey{Id: id, Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id[:1], 32)))}
+	if !notBefore.IsZero() {
+		key.NotBefore = notBefore.Unix()
+	}
+	if !expireAt.IsZero() {
+		key.ExpireAt = expireAt.Unix()
+	}
+	return key
+}
+
+func TestActiveKey(t *testing.T) {
+	now := time.Now()
+	v1 := newKey("v1", time.Time{}, now.Add(time.Hour))
+	v2 := newKey("v2", now.Add(-time.Minute), time.Time{})
+	v3 := newKey("v3", now.Add(time.Hour), time.Time{})
+	keys := []*EncryptKey{v1, v2, v3}
+
+	if ActiveKey(keys, now) != v2 {
+		t.Fatal("latest effective key should be active")
+	}
+	if FindKey(keys, "v1", now) != v1 || FindKey(keys, "v1", now.Add(2*time.Hour)) != nil {
+		t.Fatal("old key should only be usable before it expires")
+	}
+	if FindKey(keys, "v3", now) != nil {
+		t.Fatal("future key should not be usable yet")
+	}
+
+	sealed, err := Seal(v2, "app1", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := Open(v2, "app1", sealed); err != nil || string(plaintext) != "hello" {
+		t.Fatalf("unexpected plaintext %q %v", plaintext, err)
+	}
+	if _, err := Open(v2, "app2", sealed); err != ErrInvalidCipher {
+		t.Fatal("content sealed for another app should not open")
+	}
+}
+
+func TestEncryptor_Handler(t *testing.T) {
+	now := time.Now()
+	v1 := newKey("v1", time.Time{}, now.Add(time.Hour))
+	v2 := newKey("v2", now.Add(-time.Minute), time.Time{})
+
+	m := &common.Manager{}
+	m.SetCache(KeyPathPrefix+"app1", util.StructToJson(&AppKey{Secret: "s3cret", EncryptKeys: []*EncryptKey{v1, v2}}))
+
+	encryptor := &Encryptor{Keys: &ManagerKeyStore{Manager: m}}
+	route := &envoy.HTTPRoute{Prefix: "/open/", IfNeedEncryptContent: true}
+
+	var received string
+	handler := encryptor.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"echo":` + string(body) + `}`))
+	}), func(r *http.Request) *envoy.HTTPRoute { return route })
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// 只持有旧密钥的调用方在重叠窗口内仍可正常通信
+	for _, keys := range [][]*EncryptKey{{v1}, {v1, v2}} {
+		client := &http.Client{Transport: &EncryptTransport{AppId: "app1", Keys: keys}}
+
+		resp, err := client.Post(server.URL+"/open/echo", "application/json", strings.NewReader(`1`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if received != "1" || string(body) != `{"echo":1}` {
+			t.Fatalf("unexpected exchange %q %q", received, body)
+		}
+	}
+
+	resp, err := http.Post(server.URL+"/open/echo", "application/json", strings.NewReader(`2`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden || received != "1" {
+		t.Fatalf("request without app key should be rejected before reaching upstream, got %d", resp.StatusCode)
+	}
+
+	// 明文请求体不带HeaderKeyId时不能绕过加密
+	plain, _ := http.NewRequest(http.MethodPost, server.URL+"/open/echo", strings.NewReader(`3`))
+	plain.Header.Set(HeaderAppId, "app1")
+	resp, err = http.DefaultClient.Do(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden || received != "1" {
+		t.Fatalf("plaintext body without key id should be rejected, got %d", resp.StatusCode)
+	}
+
+	empty, _ := http.NewRequest(http.MethodGet, server.URL+"/open/echo", nil)
+	empty.Header.Set(HeaderAppId, "app1")
+	resp, err = http.DefaultClient.Do(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || received != "" {
+		t.Fatalf("request without body does not need a key id, got %d %q", resp.StatusCode, received)
+	}
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestEncryptPlugin_Signed(t *testing.T) {
+	now := time.Now()
+	v1 := newKey("v1", time.Time{}, time.Time{})
+
+	m := &common.Manager{}
+	m.SetCache(KeyPathPrefix+"app1", util.StructToJson(&AppKey{Secret: "s3cret", EncryptKeys: []*EncryptKey{v1}}))
+	keys := &ManagerKeyStore{Manager: m}
+
+	RegisterPlugin("openapi-test-sign", &Verifier{Keys: keys, Nonces: NewMemoryNonceStore()})
+	RegisterEncryptPlugin("openapi-test-encrypt", &Encryptor{Keys: keys})
+
+	route := &envoy.HTTPRoute{Prefix: "/open/", IfNeedVerifySign: true, IfNeedEncryptContent: true}
+	lds := &envoy.LDS{
+		Plugins:   []*envoy.Plugins{{PluginName: "openapi-test-sign"}, {PluginName: "openapi-test-encrypt"}},
+		Listeners: []*envoy.Listener{{Routes: []*envoy.HTTPRoute{route}}},
+	}
+	chain, err := plugin.Build(lds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := &plugin.Context{LDS: lds, Route: route, Request: r, Values: make(map[string]interface{})}
+		if err := chain.Route(route).OnRequest(ctx); err != nil {
+			plugin.WriteError(w, err)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+
+		ctx.Response = &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader(`{"echo":` + received + `}`))}
+		if err := chain.Route(route).OnResponse(ctx); err != nil {
+			plugin.WriteError(w, err)
+			return
+		}
+
+		for k, v := range ctx.Response.Header {
+			w.Header()[k] = v
+		}
+		_, _ = io.Copy(w, ctx.Response.Body)
+	}))
+	defer server.Close()
+
+	// 与文档中的调用方配置一致：先加密，再对密文签名
+	signer := &Signer{AppId: "app1", Secret: "s3cret", Now: func() time.Time { return now }}
+	client := &http.Client{Transport: &EncryptTransport{AppId: "app1", Keys: []*EncryptKey{v1}, Base: &Transport{Signer: signer}}}
+
+	resp, err := client.Post(server.URL+"/open/echo", "application/json", strings.NewReader(`1`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || received != "1" || string(body) != `{"echo":1}` {
+		t.Fatalf("unexpected exchange %d %q %q", resp.StatusCode, received, body)
+	}
+
+	// 签名之后替换密文应被拒绝
+	tampering := &Transport{Signer: signer, Base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sealed, _ := Seal(v1, "app1", []byte(`2`))
+		r.Body = ioutil.NopCloser(bytes.NewReader(sealed))
+		r.ContentLength = int64(len(sealed))
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	client = &http.Client{Transport: &EncryptTransport{AppId: "app1", Keys: []*EncryptKey{v1}, Base: tampering}}
+
+	resp, err = client.Post(server.URL+"/open/echo", "application/json", strings.NewReader(`1`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden || received != "1" {
+		t.Fatalf("ciphertext replaced after signing should be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestEncryptor_MaxBodySize(t *testing.T) {
+	v1 := newKey("v1", time.Time{}, time.Time{})
+
+	m := &common.Manager{}
+	m.SetCache(KeyPathPrefix+"app1", util.StructToJson(&AppKey{Secret: "s3cret", EncryptKeys: []*EncryptKey{v1}}))
+	encryptor := &Encryptor{Keys: &ManagerKeyStore{Manager: m}, MaxBodySize: 16}
+
+	sealed, _ := Seal(v1, "app1", []byte(strings.Repeat("x", 64)))
+	r := httptest.NewRequest(http.MethodPost, "http://open.example.com/open/echo", bytes.NewReader(sealed))
+	r.Header.Set(HeaderAppId, "app1")
+	r.Header.Set(HeaderKeyId, "v1")
+	if err := encryptor.DecryptRequest(r); err != ErrBodyTooLarge {
+		t.Fatalf("expected ErrBodyTooLarge for request, got %v", err)
+	}
+
+	resp := &http.Response{Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 64)))}
+	if err := encryptor.EncryptResponse("app1", "v1", resp); err != ErrBodyTooLarge {
+		t.Fatalf("expected ErrBodyTooLarge for response, got %v", err)
+	}
+}
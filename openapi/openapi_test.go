@@ -0,0 +1,169 @@
+package openapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func newVerifier(now time.Time) *Verifier {
+	m := &common.Manager{}
+	m.SetCache(KeyPathPrefix+"app1", util.StructToJson(&AppKey{Secret: "s3cret"}))
+	m.SetCache(KeyPathPrefix+"app2", util.StructToJson(&AppKey{Secret: "disabled", Status: util.No}))
+
+	return &Verifier{
+		Keys:   &ManagerKeyStore{Manager: m},
+		Nonces: NewMemoryNonceStore(),
+		Now:    func() time.Time { return now },
+	}
+}
+
+func newSignedRequest(t *testing.T, signer *Signer, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "http://open.example.com/open/order?b=2&a=1&a=0", strings.NewReader(body))
+	if err := signer.Sign(r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	verifier := newVerifier(now)
+	signer := &Signer{AppId: "app1", Secret: "s3cret", Now: func() time.Time { return now }}
+
+	r := newSignedRequest(t, signer, `{"id":1}`)
+	if err := verifier.Verify(r); err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != `{"id":1}` {
+		t.Fatalf("body should be readable after verification, got %q", body)
+	}
+
+	if err := verifier.Verify(newSignedRequest(t, signer, `{"id":1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := newSignedRequest(t, signer, `{"id":1}`)
+	replayed.Header.Set(HeaderNonce, r.Header.Get(HeaderNonce))
+	replayed.Header.Set(HeaderSignature, r.Header.Get(HeaderSignature))
+	if err := verifier.Verify(replayed); err != ErrNonceReplayed {
+		t.Fatalf("expected replay error, got %v", err)
+	}
+
+	tampered := newSignedRequest(t, signer, `{"id":1}`)
+	tampered.Body = ioutil.NopCloser(strings.NewReader(`{"id":2}`))
+	if err := verifier.Verify(tampered); err != ErrInvalidSignature {
+		t.Fatalf("expected signature error, got %v", err)
+	}
+
+	old := &Signer{AppId: "app1", Secret: "s3cret", Now: func() time.Time { return now.Add(-10 * time.Minute) }}
+	if err := verifier.Verify(newSignedRequest(t, old, "")); err != ErrTimestampExpired {
+		t.Fatalf("expected timestamp error, got %v", err)
+	}
+
+	disabled := &Signer{AppId: "app2", Secret: "disabled", Now: signer.Now}
+	if err := verifier.Verify(newSignedRequest(t, disabled, "")); err != ErrUnknownApp {
+		t.Fatalf("expected unknown app error, got %v", err)
+	}
+
+	if err := verifier.Verify(httptest.NewRequest(http.MethodGet, "http://open.example.com/", nil)); err != ErrMissingHeaders {
+		t.Fatalf("expected missing headers error, got %v", err)
+	}
+
+	verifier.MaxBodySize = 8
+	if err := verifier.Verify(newSignedRequest(t, signer, `{"id":123456}`)); err != ErrBodyTooLarge {
+		t.Fatalf("expected body too large error, got %v", err)
+	}
+}
+
+type countingConfig struct {
+	values map[string]string
+	gets   int
+}
+
+func (c *countingConfig) Get(path string) interface{} {
+	c.gets++
+	return c.values[path]
+}
+
+func TestManagerKeyStore_NegativeCache(t *testing.T) {
+	config := &countingConfig{values: map[string]string{KeyPathPrefix + "app1": util.StructToJson(&AppKey{Secret: "s3cret"})}}
+	store := &ManagerKeyStore{Manager: config}
+
+	for i := 0; i < 3; i++ {
+		if store.Secret("unknown") != "" || store.Secret("app1") != "s3cret" {
+			t.Fatal("unexpected secret")
+		}
+	}
+	if config.gets != 4 {
+		t.Fatalf("unknown app should be looked up once, got %d lookups", config.gets)
+	}
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	store := NewMemoryNonceStore()
+
+	if !store.Add("a", time.Millisecond) || store.Add("a", time.Millisecond) {
+		t.Fatal("nonce should only be added once within ttl")
+	}
+	if !store.Add("b", time.Hour) {
+		t.Fatal("other nonce should be added")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if !store.Add("a", time.Hour) {
+		t.Fatal("expired nonce should be added again")
+	}
+	if len(store.nonces) != 2 || len(store.expires) != 2 {
+		t.Fatalf("expired nonces should be swept, got %d %d", len(store.nonces), len(store.expires))
+	}
+}
+
+func TestHandler(t *testing.T) {
+	now := time.Now()
+	verifier := newVerifier(now)
+
+	route := &envoy.HTTPRoute{Prefix: "/open/", IfNeedVerifySign: true, Method: http.MethodPost}
+	handler := verifier.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), func(r *http.Request) *envoy.HTTPRoute { return route })
+
+	backend := httptest.NewServer(handler)
+	defer backend.Close()
+
+	client := &http.Client{Transport: &Transport{Signer: &Signer{AppId: "app1", Secret: "s3cret"}}}
+
+	resp, err := client.Post(backend.URL+"/open/order?x=1", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(backend.URL + "/open/order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(backend.URL+"/open/order", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,143 @@
+package openapi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mgcicd/cicd-core/util"
+)
+
+const (
+	HeaderAppId     = "X-App-Id"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature"
+)
+
+// CanonicalRequest 参与签名的规范化请求，各部分以\n连接：
+// METHOD、PATH、按key排序并编码的query、AppId、Timestamp、Nonce、body的sha256(hex)
+func CanonicalRequest(method string, path string, query url.Values, appId string, timestamp string, nonce string, body []byte) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	bodyHash := sha256.Sum256(body)
+
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		strings.Join(pairs, "&"),
+		appId,
+		timestamp,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+// Signature HMAC-SHA256(secret, canonical)的base64
+func Signature(secret string, canonical string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonical))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// readBody 读取请求体并替换为可重复读取的副本，limit大于0时超过limit字节返回ErrBodyTooLarge
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	reader := r.Body
+	if limit > 0 {
+		reader = http.MaxBytesReader(nil, r.Body, limit)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	r.Body.Close()
+	if err != nil {
+		if limit > 0 && int64(len(body)) >= limit {
+			return nil, ErrBodyTooLarge
+		}
+		return nil, err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// Signer 调用方使用的签名器
+type Signer struct {
+	AppId  string
+	Secret string
+	// Now 为空时使用time.Now
+	Now func() time.Time
+}
+
+// Sign 为请求写入AppId、Timestamp、Nonce、Signature头
+func (s *Signer) Sign(r *http.Request) error {
+	body, err := readBody(r, 0)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	nonce := util.UniqueId()
+
+	canonical := CanonicalRequest(r.Method, r.URL.EscapedPath(), r.URL.Query(), s.AppId, timestamp, nonce, body)
+
+	r.Header.Set(HeaderAppId, s.AppId)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, Signature(s.Secret, canonical))
+
+	return nil
+}
+
+// Transport 发送前为每个请求签名的http.RoundTripper，Base为空时使用http.DefaultTransport
+type Transport struct {
+	Signer *Signer
+	Base   http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	if err := t.Signer.Sign(r); err != nil {
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
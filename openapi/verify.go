@@ -0,0 +1,348 @@
+package openapi
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/plugin"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+const KeyPathPrefix = "/config/openapi/"
+
+var (
+	ErrMissingHeaders   = errors.New("missing signature headers")
+	ErrUnknownApp       = errors.New("unknown app")
+	ErrTimestampExpired = errors.New("timestamp expired")
+	ErrNonceReplayed    = errors.New("nonce replayed")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	ErrBodyTooLarge     = errors.New("request body too large")
+)
+
+// ErrorStatus 校验错误对应的HTTP状态码
+func ErrorStatus(err error) int {
+	switch err {
+	case nil:
+		return http.StatusOK
+	case ErrMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case ErrBodyTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrUnknownApp, ErrInvalidSignature:
+		return http.StatusForbidden
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// AppKey /config/openapi/{appId}节点的内容
+type AppKey struct {
+	Secret      string
+	Status      util.YesOrNo
+	EncryptKeys []*EncryptKey //加解密内容使用的密钥，按Id区分版本
+}
+
+// KeyStore 按AppId查询签名密钥，不存在或已禁用时返回空
+type KeyStore interface {
+	Secret(appId string) string
+}
+
+// ConfigStore 读取配置节点，*common.Manager实现了该接口
+type ConfigStore interface {
+	Get(path string) interface{}
+}
+
+// 未知appId的负缓存上限，超过时先清理过期项，仍然超过则整体清空，避免随机appId撑大内存
+const maxMisses = 10000
+
+// ManagerKeyStore 从Manager读取/config/openapi/{appId}，Manager缓存中没有的节点会查询zk，
+// 不存在的appId在NegativeTTL内不再查询，避免伪造的appId每次都访问zk
+type ManagerKeyStore struct {
+	Manager ConfigStore
+	// NegativeTTL 为0时为1分钟
+	NegativeTTL time.Duration
+
+	mu     sync.Mutex
+	misses map[string]time.Time
+}
+
+func (s *ManagerKeyStore) missed(appId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expireAt, ok := s.misses[appId]
+	if ok && time.Now().After(expireAt) {
+		delete(s.misses, appId)
+		return false
+	}
+	return ok
+}
+
+func (s *ManagerKeyStore) miss(appId string) {
+	ttl := s.NegativeTTL
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.misses) >= maxMisses {
+		for id, expireAt := range s.misses {
+			if now.After(expireAt) {
+				delete(s.misses, id)
+			}
+		}
+	}
+	if s.misses == nil || len(s.misses) >= maxMisses {
+		s.misses = make(map[string]time.Time)
+	}
+
+	s.misses[appId] = now.Add(ttl)
+}
+
+func (s *ManagerKeyStore) appKey(appId string) *AppKey {
+	if appId == "" || strings.Contains(appId, "/") || s.missed(appId) {
+		return nil
+	}
+
+	value, _ := s.Manager.Get(KeyPathPrefix + appId).(string)
+	if value == "" {
+		s.miss(appId)
+		return nil
+	}
+
+	key := &AppKey{}
+	if err := util.JsonToStruct(value, key); err != nil || key.Status == util.No {
+		return nil
+	}
+	return key
+}
+
+func (s *ManagerKeyStore) Secret(appId string) string {
+	if key := s.appKey(appId); key != nil {
+		return key.Secret
+	}
+	return ""
+}
+
+func (s *ManagerKeyStore) EncryptKeys(appId string) []*EncryptKey {
+	if key := s.appKey(appId); key != nil {
+		return key.EncryptKeys
+	}
+	return nil
+}
+
+// NonceStore 记录已使用的nonce，Add在nonce已存在时返回false
+type NonceStore interface {
+	Add(nonce string, ttl time.Duration) bool
+}
+
+// MemoryNonceStore 进程内的NonceStore，多实例部署时应使用共享存储
+// 过期时间按小顶堆排列，每次Add只清理堆顶已过期的nonce
+type MemoryNonceStore struct {
+	mu      sync.Mutex
+	nonces  map[string]time.Time
+	expires nonceHeap
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+func (s *MemoryNonceStore) Add(nonce string, ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for len(s.expires) > 0 && now.After(s.expires[0].expireAt) {
+		expired := heap.Pop(&s.expires).(nonceExpire)
+		if s.nonces[expired.nonce] == expired.expireAt {
+			delete(s.nonces, expired.nonce)
+		}
+	}
+
+	if expireAt, ok := s.nonces[nonce]; ok && !now.After(expireAt) {
+		return false
+	}
+
+	expireAt := now.Add(ttl)
+	s.nonces[nonce] = expireAt
+	heap.Push(&s.expires, nonceExpire{nonce: nonce, expireAt: expireAt})
+	return true
+}
+
+type nonceExpire struct {
+	nonce    string
+	expireAt time.Time
+}
+
+type nonceHeap []nonceExpire
+
+func (h nonceHeap) Len() int            { return len(h) }
+func (h nonceHeap) Less(i, j int) bool  { return h[i].expireAt.Before(h[j].expireAt) }
+func (h nonceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x interface{}) { *h = append(*h, x.(nonceExpire)) }
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Verifier 服务端签名校验，时间戳与服务端时间相差超过Window的请求被拒绝，
+// Window内同一AppId的nonce只能使用一次
+type Verifier struct {
+	Keys   KeyStore
+	Nonces NonceStore
+	// Window 为0时为5分钟
+	Window time.Duration
+	// MaxBodySize 签名校验前最多读取的请求体大小，为0时为1MB
+	MaxBodySize int64
+	// Now 为空时使用time.Now
+	Now func() time.Time
+}
+
+func (v *Verifier) maxBodySize() int64 {
+	if v.MaxBodySize <= 0 {
+		return 1 << 20
+	}
+	return v.MaxBodySize
+}
+
+func (v *Verifier) window() time.Duration {
+	if v.Window <= 0 {
+		return 5 * time.Minute
+	}
+	return v.Window
+}
+
+// Verify 校验请求签名，校验通过后请求体仍可读取
+func (v *Verifier) Verify(r *http.Request) error {
+	return v.verify(r, func() ([]byte, error) {
+		return readBody(r, v.maxBodySize())
+	})
+}
+
+// verify signedBody返回签名时使用的请求体，请求体已被解密替换时为原始密文
+func (v *Verifier) verify(r *http.Request, signedBody func() ([]byte, error)) error {
+	appId := r.Header.Get(HeaderAppId)
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	signature := r.Header.Get(HeaderSignature)
+
+	if appId == "" || timestamp == "" || nonce == "" || signature == "" {
+		return ErrMissingHeaders
+	}
+
+	secret := v.Keys.Secret(appId)
+	if secret == "" {
+		return ErrUnknownApp
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrTimestampExpired
+	}
+
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+
+	diff := now().Sub(time.Unix(seconds, 0))
+	if diff > v.window() || diff < -v.window() {
+		return ErrTimestampExpired
+	}
+
+	body, err := signedBody()
+	if err != nil {
+		return err
+	}
+
+	canonical := CanonicalRequest(r.Method, r.URL.EscapedPath(), r.URL.Query(), appId, timestamp, nonce, body)
+	if !hmac.Equal([]byte(Signature(secret, canonical)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	// 签名通过后才记录nonce，避免伪造请求占用nonce
+	if v.Nonces != nil && !v.Nonces.Add(appId+":"+nonce, 2*v.window()) {
+		return ErrNonceReplayed
+	}
+
+	return nil
+}
+
+// VerifyRoute 路由开启IfNeedVerifySign时校验签名；路由配置了Method时同时校验请求方法
+func (v *Verifier) VerifyRoute(r *http.Request, route *envoy.HTTPRoute) error {
+	return v.verifyRoute(r, route, nil)
+}
+
+func (v *Verifier) verifyRoute(r *http.Request, route *envoy.HTTPRoute, signedBody func() ([]byte, error)) error {
+	if route == nil {
+		return nil
+	}
+
+	if route.Method != "" && !strings.EqualFold(route.Method, r.Method) {
+		return ErrMethodNotAllowed
+	}
+
+	if !route.IfNeedVerifySign {
+		return nil
+	}
+
+	if signedBody == nil {
+		return v.Verify(r)
+	}
+	return v.verify(r, signedBody)
+}
+
+// Handler 校验失败时直接返回，routeFor返回请求命中的路由，比如proxy.Proxy.Lookup
+func (v *Verifier) Handler(next http.Handler, routeFor func(r *http.Request) *envoy.HTTPRoute) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.VerifyRoute(r, routeFor(r)); err != nil {
+			http.Error(w, err.Error(), ErrorStatus(err))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type verifyPlugin struct {
+	verifier *Verifier
+}
+
+// OnAuth 加解密插件已在PhaseRequest解密请求体时，按其保存的原始密文校验签名
+func (p *verifyPlugin) OnAuth(ctx *plugin.Context) error {
+	var signedBody func() ([]byte, error)
+	if raw, ok := ctx.Values[rawBodyValue].([]byte); ok {
+		signedBody = func() ([]byte, error) {
+			return raw, nil
+		}
+	}
+
+	if err := p.verifier.verifyRoute(ctx.Request, ctx.Route, signedBody); err != nil {
+		return plugin.Reject(ErrorStatus(err), err.Error())
+	}
+	return nil
+}
+
+// RegisterPlugin 以name注册签名校验插件，只对开启了IfNeedVerifySign或配置了Method的路由生效
+func RegisterPlugin(name string, verifier *Verifier) {
+	plugin.Register(name, plugin.PhaseAuth, func(lds *envoy.LDS, route *envoy.HTTPRoute) (plugin.Plugin, error) {
+		if !route.IfNeedVerifySign && route.Method == "" {
+			return nil, nil
+		}
+		return &verifyPlugin{verifier: verifier}, nil
+	})
+}
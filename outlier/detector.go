@@ -0,0 +1,158 @@
+package outlier
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+type hostState struct {
+	consecutive5xx uint32
+	ejections      uint32
+	ejectedUntil   time.Time
+	lastDecay      time.Time
+}
+
+// Detector 被动异常点检测：根据请求结果统计各endpoint连续5xx次数，超过阈值后驱逐一段时间
+// 通过envoy.RegisterEndpointFilter注册后，开启了EnableOutlierDetection的集群在负载均衡时会跳过被驱逐的endpoint
+type Detector struct {
+	mu       sync.Mutex
+	clusters map[string]map[string]*hostState
+	now      func() time.Time
+	percent  func() uint32
+}
+
+func NewDetector() *Detector {
+	return &Detector{
+		clusters: make(map[string]map[string]*hostState),
+		now:      time.Now,
+		percent: func() uint32 {
+			return uint32(rand.Intn(100))
+		},
+	}
+}
+
+func hostKey(ep *envoy.Endpoint) string {
+	return ep.Ip + ":" + strconv.Itoa(ep.Port)
+}
+
+func (d *Detector) host(eds *envoy.EDS, ep *envoy.Endpoint) *hostState {
+	hosts := d.clusters[eds.ClusterKey()]
+	if hosts == nil {
+		hosts = make(map[string]*hostState)
+		d.clusters[eds.ClusterKey()] = hosts
+	}
+
+	state := hosts[hostKey(ep)]
+	if state == nil {
+		state = &hostState{lastDecay: d.now()}
+		hosts[hostKey(ep)] = state
+	}
+
+	return state
+}
+
+// Report 上报一次请求结果，连接失败等网关错误按502上报
+func (d *Detector) Report(eds *envoy.EDS, ep *envoy.Endpoint, statusCode int) {
+	if eds == nil || ep == nil || eds.EnableOutlierDetection != util.True {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	state := d.host(eds, ep)
+	d.decay(eds, state, now)
+
+	if statusCode < 500 {
+		state.consecutive5xx = 0
+		return
+	}
+
+	state.consecutive5xx++
+	if state.consecutive5xx < eds.OutlierDetection.GetConsecutive5xx() {
+		return
+	}
+	state.consecutive5xx = 0
+
+	if now.Before(state.ejectedUntil) {
+		return
+	}
+
+	if d.percent() >= eds.OutlierDetection.GetEnforcingConsecutive5xx() {
+		return
+	}
+
+	if d.ejectedPercent(eds, now) >= eds.OutlierDetection.GetMaxEjectionPercent() {
+		return
+	}
+
+	state.ejections++
+	state.ejectedUntil = now.Add(eds.OutlierDetection.GetBaseEjectionTime() * time.Duration(state.ejections))
+}
+
+// decay 未被驱逐的endpoint每经过一个Interval驱逐次数减一，驱逐时长随之回落
+func (d *Detector) decay(eds *envoy.EDS, state *hostState, now time.Time) {
+	if now.Before(state.ejectedUntil) {
+		return
+	}
+
+	since := state.lastDecay
+	if state.ejectedUntil.After(since) {
+		since = state.ejectedUntil
+	}
+
+	interval := eds.OutlierDetection.GetInterval()
+	elapsed := uint32(now.Sub(since) / interval)
+	if elapsed == 0 {
+		return
+	}
+
+	if elapsed >= state.ejections {
+		state.ejections = 0
+	} else {
+		state.ejections -= elapsed
+	}
+	state.lastDecay = since.Add(interval * time.Duration(elapsed))
+}
+
+func (d *Detector) ejectedPercent(eds *envoy.EDS, now time.Time) uint32 {
+	if len(eds.Endpoints) == 0 {
+		return 100
+	}
+
+	hosts := d.clusters[eds.ClusterKey()]
+
+	var ejected int
+	for _, ep := range eds.Endpoints {
+		if state := hosts[hostKey(ep)]; state != nil && now.Before(state.ejectedUntil) {
+			ejected++
+		}
+	}
+
+	return uint32(ejected * 100 / len(eds.Endpoints))
+}
+
+// Ejected endpoint当前是否处于驱逐中
+func (d *Detector) Ejected(eds *envoy.EDS, ep *envoy.Endpoint) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := d.clusters[eds.ClusterKey()][hostKey(ep)]
+
+	return state != nil && d.now().Before(state.ejectedUntil)
+}
+
+// Available 实现envoy.EndpointFilter，未开启异常点检测的集群始终可用
+func (d *Detector) Available(eds *envoy.EDS, ep *envoy.Endpoint) bool {
+	if eds.EnableOutlierDetection != util.True {
+		return true
+	}
+
+	return !d.Ejected(eds, ep)
+}
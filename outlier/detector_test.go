@@ -0,0 +1,164 @@
+package outlier
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func newEDS() *envoy.EDS {
+	return &envoy.EDS{
+		Name: "order",
+		Endpoints: []*envoy.Endpoint{
+			{Ip: "10.0.0.1", Port: 80},
+			{Ip: "10.0.0.2", Port: 80},
+			{Ip: "10.0.0.3", Port: 80},
+			{Ip: "10.0.0.4", Port: 80},
+		},
+		EnableOutlierDetection: util.True,
+		OutlierDetection: envoy.OutlierDetection{
+			Consecutive_5Xx:    2,
+			BaseEjectionTime:   1,
+			Interval:           1,
+			MaxEjectionPercent: 50,
+		},
+	}
+}
+
+func TestDetector_Eject(t *testing.T) {
+	now := time.Unix(0, 0)
+	d := NewDetector()
+	d.now = func() time.Time { return now }
+
+	eds := newEDS()
+	ep := eds.Endpoints[0]
+
+	d.Report(eds, ep, http.StatusInternalServerError)
+	d.Report(eds, ep, http.StatusOK)
+	d.Report(eds, ep, http.StatusInternalServerError)
+	if d.Ejected(eds, ep) {
+		t.Fatal("success should reset consecutive 5xx")
+	}
+
+	d.Report(eds, ep, http.StatusBadGateway)
+	if !d.Ejected(eds, ep) || d.Available(eds, ep) {
+		t.Fatal("endpoint should be ejected")
+	}
+
+	now = now.Add(time.Second)
+	if d.Ejected(eds, ep) {
+		t.Fatal("endpoint should be back after base ejection time")
+	}
+
+	d.Report(eds, ep, http.StatusBadGateway)
+	d.Report(eds, ep, http.StatusBadGateway)
+	now = now.Add(time.Second)
+	if !d.Ejected(eds, ep) {
+		t.Fatal("second ejection should last twice as long")
+	}
+
+	eds.EnableOutlierDetection = util.False
+	if !d.Available(eds, ep) {
+		t.Fatal("endpoint should be available when outlier detection is disabled")
+	}
+}
+
+func TestDetector_MaxEjectionPercent(t *testing.T) {
+	d := NewDetector()
+	eds := newEDS()
+
+	for _, ep := range eds.Endpoints {
+		d.Report(eds, ep, http.StatusServiceUnavailable)
+		d.Report(eds, ep, http.StatusServiceUnavailable)
+	}
+
+	var ejected int
+	for _, ep := range eds.Endpoints {
+		if d.Ejected(eds, ep) {
+			ejected++
+		}
+	}
+
+	if ejected != 2 {
+		t.Fatalf("expected 2 ejected endpoints, got %d", ejected)
+	}
+}
+
+func TestDetector_Enforcing(t *testing.T) {
+	//0与旧节点一致按100处理
+	cases := []struct {
+		enforcing uint32
+		percent   uint32
+		ejected   bool
+	}{{50, 60, false}, {50, 40, true}, {0, 99, true}}
+
+	for _, c := range cases {
+		d := NewDetector()
+		percent := c.percent
+		d.percent = func() uint32 { return percent }
+
+		eds := newEDS()
+		eds.OutlierDetection.EnforcingConsecutive_5Xx = c.enforcing
+
+		ep := eds.Endpoints[0]
+		d.Report(eds, ep, http.StatusInternalServerError)
+		d.Report(eds, ep, http.StatusInternalServerError)
+
+		if d.Ejected(eds, ep) != c.ejected {
+			t.Fatalf("enforcing %d%% with %d: expected ejected=%v", c.enforcing, c.percent, c.ejected)
+		}
+	}
+}
+
+func TestDetector_ClusterKey(t *testing.T) {
+	d := NewDetector()
+
+	a := newEDS()
+	a.Name = ""
+	a.NodeName = "order"
+	b := newEDS()
+	b.Name = ""
+	b.NodeName = "user"
+
+	ep := a.Endpoints[0]
+	d.Report(a, ep, http.StatusInternalServerError)
+	d.Report(a, ep, http.StatusInternalServerError)
+
+	if !d.Ejected(a, ep) {
+		t.Fatal("endpoint should be ejected")
+	}
+	if d.Ejected(b, b.Endpoints[0]) {
+		t.Fatal("clusters without Name should not share state")
+	}
+}
+
+func TestDetector_LoadBalancer(t *testing.T) {
+	d := NewDetector()
+	envoy.RegisterEndpointFilter(d)
+
+	eds := newEDS()
+	eds.OutlierDetection.MaxEjectionPercent = 100
+	for _, ep := range eds.Endpoints[1:] {
+		d.Report(eds, ep, http.StatusInternalServerError)
+		d.Report(eds, ep, http.StatusInternalServerError)
+	}
+
+	for i := 0; i < len(eds.Endpoints); i++ {
+		ep, err := eds.GetEndpoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ep != eds.Endpoints[0] {
+			t.Fatalf("ejected endpoint %s was selected", ep.Ip)
+		}
+	}
+
+	d.Report(eds, eds.Endpoints[0], http.StatusInternalServerError)
+	d.Report(eds, eds.Endpoints[0], http.StatusInternalServerError)
+	if _, err := eds.GetEndpoint(); err == nil {
+		t.Fatal("expected error when all endpoints are ejected")
+	}
+}
@@ -0,0 +1,248 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+type instance struct {
+	name   string
+	phases Phase
+	plugin Plugin
+}
+
+// RouteChain 某个路由按LDS.Plugins声明顺序排列的插件实例
+type RouteChain struct {
+	instances []*instance
+	broken    bool //插件链构建失败，拒绝所有请求
+}
+
+// Chain 一个LDS文档中每个路由的插件链
+type Chain struct {
+	lds    *envoy.LDS
+	routes map[*envoy.HTTPRoute]*RouteChain
+	broken bool
+}
+
+// ErrChainUnavailable 插件链构建失败时拒绝请求，避免认证、签名、参数校验等插件配置错误时请求直接放行
+var ErrChainUnavailable = Reject(http.StatusServiceUnavailable, "plugin chain unavailable")
+
+// Build 为LDS中的每个路由创建插件链，有插件未注册或创建失败时返回拒绝该LDS所有请求的插件链和error
+func Build(lds *envoy.LDS) (*Chain, error) {
+	chain := &Chain{lds: lds, routes: make(map[*envoy.HTTPRoute]*RouteChain)}
+
+	var errs []string
+
+	for _, listener := range lds.Listeners {
+		if listener == nil {
+			continue
+		}
+
+		for _, route := range listener.Routes {
+			if route == nil {
+				continue
+			}
+
+			routeChain := &RouteChain{}
+
+			for _, p := range lds.Plugins {
+				if p == nil {
+					continue
+				}
+
+				reg := lookup(p.PluginName)
+				if reg == nil {
+					errs = append(errs, "plugin "+p.PluginName+" not registered")
+					continue
+				}
+
+				phases := reg.phases
+				if p.Phases != 0 {
+					phases &= Phase(p.Phases)
+					if phases == 0 {
+						errs = append(errs, fmt.Sprintf("plugin %s does not support phases %d", p.PluginName, p.Phases))
+						continue
+					}
+				}
+
+				instancePlugin, err := reg.factory(lds, route)
+				if err != nil {
+					errs = append(errs, "plugin "+p.PluginName+" route "+route.Prefix+": "+err.Error())
+					continue
+				}
+				if instancePlugin == nil {
+					continue
+				}
+
+				routeChain.instances = append(routeChain.instances, &instance{name: p.PluginName, phases: phases, plugin: instancePlugin})
+			}
+
+			chain.routes[route] = routeChain
+		}
+	}
+
+	if len(errs) > 0 {
+		return &Chain{lds: lds, broken: true}, errors.New(strings.Join(uniq(errs), "; "))
+	}
+
+	return chain, nil
+}
+
+func uniq(values []string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// Route 路由的插件链，路由不属于该LDS时返回空链
+func (c *Chain) Route(route *envoy.HTTPRoute) *RouteChain {
+	if c.broken {
+		return &RouteChain{broken: true}
+	}
+	if routeChain, ok := c.routes[route]; ok {
+		return routeChain
+	}
+	return &RouteChain{}
+}
+
+// Names 插件链中的插件名称，按执行顺序
+func (rc *RouteChain) Names() []string {
+	names := make([]string, 0, len(rc.instances))
+	for _, i := range rc.instances {
+		names = append(names, i.name)
+	}
+	return names
+}
+
+// OnRequest 依次执行PhaseRequest和PhaseAuth阶段，任一插件返回错误时中断
+func (rc *RouteChain) OnRequest(ctx *Context) error {
+	if rc.broken {
+		return ErrChainUnavailable
+	}
+
+	for _, i := range rc.instances {
+		if handler, ok := i.plugin.(RequestHandler); ok && i.phases.Has(PhaseRequest) {
+			if err := handler.OnRequest(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, i := range rc.instances {
+		if handler, ok := i.plugin.(AuthHandler); ok && i.phases.Has(PhaseAuth) {
+			if err := handler.OnAuth(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// OnResponse 按声明顺序执行PhaseResponse阶段
+func (rc *RouteChain) OnResponse(ctx *Context) error {
+	if rc.broken {
+		return ErrChainUnavailable
+	}
+
+	for _, i := range rc.instances {
+		if handler, ok := i.plugin.(ResponseHandler); ok && i.phases.Has(PhaseResponse) {
+			if err := handler.OnResponse(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Chains 按LDS缓存插件链，/lds变更时清空缓存，下次使用时重新构建
+type Chains struct {
+	manager *common.Manager
+	mu      sync.Mutex
+	chains  map[*envoy.LDS]*Chain
+	stop    chan struct{}
+}
+
+func NewChains(m *common.Manager) *Chains {
+	c := &Chains{
+		manager: m,
+		chains:  make(map[*envoy.LDS]*Chain),
+		stop:    make(chan struct{}),
+	}
+
+	lds := m.Subscribe("/lds/")
+
+	go c.watch(lds)
+
+	return c
+}
+
+func (c *Chains) watch(lds <-chan common.ChangedEvent) {
+	for {
+		select {
+		case <-lds:
+			c.mu.Lock()
+			c.chains = make(map[*envoy.LDS]*Chain)
+			c.mu.Unlock()
+		case <-c.stop:
+			c.manager.Unsubscribe(lds)
+			return
+		}
+	}
+}
+
+func (c *Chains) Close() {
+	close(c.stop)
+}
+
+// Get LDS的插件链，构建失败时打印日志并返回拒绝所有请求的插件链，/lds变更后重新构建
+func (c *Chains) Get(lds *envoy.LDS) *Chain {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if chain, ok := c.chains[lds]; ok {
+		return chain
+	}
+
+	chain, err := Build(lds)
+	if err != nil {
+		log.Printf("plugin: lds %s: %v\n", lds.Name, err)
+	}
+	c.chains[lds] = chain
+
+	return chain
+}
+
+// WriteError 把插件中断请求的错误写给客户端，实现了ErrorWriter的错误自行写出响应，其余按Status返回文本
+func WriteError(w http.ResponseWriter, err error) {
+	var writer ErrorWriter
+	if errors.As(err, &writer) {
+		writer.WriteTo(w)
+		return
+	}
+
+	http.Error(w, err.Error(), Status(err))
+}
+
+// Status 插件返回的错误对应的HTTP状态码，非*Error时为500
+func Status(err error) int {
+	var pluginErr *Error
+	if errors.As(err, &pluginErr) {
+		return pluginErr.Status
+	}
+	return http.StatusInternalServerError
+}
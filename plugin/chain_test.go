@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+type recorder struct {
+	name string
+}
+
+func (r *recorder) OnRequest(ctx *Context) error {
+	ctx.Request.Header.Add("X-Plugins", r.name+":request")
+	return nil
+}
+
+func (r *recorder) OnAuth(ctx *Context) error {
+	ctx.Request.Header.Add("X-Plugins", r.name+":auth")
+	if ctx.Route.Auth == 1 && ctx.Request.Header.Get("Authorization") == "" {
+		return Reject(http.StatusUnauthorized, "unauthorized")
+	}
+	return nil
+}
+
+func (r *recorder) OnResponse(ctx *Context) error {
+	ctx.Response.Header.Add("X-Plugins", r.name+":response")
+	return nil
+}
+
+func init() {
+	Register("test-a", PhaseRequest|PhaseAuth|PhaseResponse, func(lds *envoy.LDS, route *envoy.HTTPRoute) (Plugin, error) {
+		return &recorder{name: "a"}, nil
+	})
+	Register("test-b", PhaseRequest|PhaseAuth, func(lds *envoy.LDS, route *envoy.HTTPRoute) (Plugin, error) {
+		if route.Prefix == "/public/" {
+			return nil, nil
+		}
+		return &recorder{name: "b"}, nil
+	})
+}
+
+func newLDS() *envoy.LDS {
+	return &envoy.LDS{
+		Name: "api",
+		Plugins: []*envoy.Plugins{
+			{PluginName: "test-b"},
+			{PluginName: "test-a", FunctionalTypes: 2, Phases: int32(PhaseRequest | PhaseResponse)},
+		},
+		Listeners: []*envoy.Listener{{
+			Domains: []string{"api.example.com"},
+			Routes: []*envoy.HTTPRoute{
+				{Prefix: "/user/", ClusterName: "user", Auth: 1},
+				{Prefix: "/public/", ClusterName: "public"},
+			},
+		}},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	lds := newLDS()
+
+	chain, err := Build(lds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := chain.Route(lds.Listeners[0].Routes[0])
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/user/info", nil)
+	r.Header.Set("Authorization", "token")
+
+	ctx := &Context{LDS: lds, Route: lds.Listeners[0].Routes[0], Request: r}
+	if err := user.OnRequest(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx.Response = &http.Response{Header: http.Header{}}
+	if err := user.OnResponse(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"b:request", "a:request", "b:auth"}
+	got := r.Header["X-Plugins"]
+	if len(got) != len(expected) {
+		t.Fatalf("unexpected execution order %v", got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("unexpected execution order %v", got)
+		}
+	}
+	if ctx.Response.Header.Get("X-Plugins") != "a:response" {
+		t.Fatalf("unexpected response plugins %v", ctx.Response.Header)
+	}
+
+	r.Header.Del("Authorization")
+	if err := user.OnRequest(ctx); Status(err) != http.StatusUnauthorized {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	public := chain.Route(lds.Listeners[0].Routes[1])
+	if names := public.Names(); len(names) != 1 || names[0] != "test-a" {
+		t.Fatalf("unexpected public chain %v", names)
+	}
+
+	//Phases与插件注册的阶段没有交集时报错，不能静默禁用插件
+	lds.Plugins[0].Phases = int32(PhaseResponse)
+	if _, err := Build(lds); err == nil {
+		t.Fatal("phases not supported by the plugin should be reported")
+	}
+	lds.Plugins[0].Phases = 0
+
+	lds.Plugins = append(lds.Plugins, &envoy.Plugins{PluginName: "missing"})
+	broken, err := Build(lds)
+	if err == nil {
+		t.Fatal("unregistered plugin should be reported")
+	}
+
+	//配置错误时拒绝请求，不能跳过插件放行
+	for _, route := range lds.Listeners[0].Routes {
+		ctx := &Context{LDS: lds, Route: route, Request: httptest.NewRequest(http.MethodGet, "http://api.example.com/public/", nil)}
+		if err := broken.Route(route).OnRequest(ctx); Status(err) != http.StatusServiceUnavailable {
+			t.Fatalf("broken chain should reject %s, got %v", route.Prefix, err)
+		}
+	}
+}
+
+func TestChains_RebuildOnChange(t *testing.T) {
+	m := &common.Manager{}
+	m.SetCache("/lds/api", util.StructToJson(newLDS()))
+
+	chains := NewChains(m)
+	defer chains.Close()
+
+	lds := m.GetAllLds()["api"]
+	if chains.Get(lds) != chains.Get(lds) {
+		t.Fatal("chain should be cached")
+	}
+
+	updated := newLDS()
+	updated.Plugins = updated.Plugins[:1]
+	m.SetCache("/lds/api", util.StructToJson(updated))
+
+	lds = m.GetAllLds()["api"]
+	deadline := time.Now().Add(2 * time.Second)
+	for len(chains.Get(lds).Route(lds.Listeners[0].Routes[0]).Names()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("chain was not rebuilt")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+// Phase 插件执行阶段，可按位组合；LDS中Plugins.Phases不为0时只执行与其相交的阶段
+type Phase int32
+
+const (
+	PhaseRequest  Phase = 1 << iota //转发前处理请求
+	PhaseAuth                       //认证鉴权，在PhaseRequest之后执行
+	PhaseResponse                   //处理上游响应
+)
+
+func (p Phase) Has(phase Phase) bool {
+	return p&phase != 0
+}
+
+// Context 一次请求在插件链中传递的上下文
+type Context struct {
+	LDS      *envoy.LDS
+	Route    *envoy.HTTPRoute
+	Request  *http.Request
+	Response *http.Response //仅PhaseResponse阶段不为空
+	Values   map[string]interface{}
+}
+
+// Plugin 按声明的阶段实现RequestHandler、AuthHandler、ResponseHandler中的对应接口
+type Plugin interface{}
+
+type RequestHandler interface {
+	OnRequest(ctx *Context) error
+}
+
+type AuthHandler interface {
+	OnAuth(ctx *Context) error
+}
+
+type ResponseHandler interface {
+	OnResponse(ctx *Context) error
+}
+
+// Factory 为每个路由创建插件实例，路由上的配置(如Auth、Verify、VerifyParam)即插件的路由级配置
+// 返回nil表示该路由不需要此插件
+type Factory func(lds *envoy.LDS, route *envoy.HTTPRoute) (Plugin, error)
+
+// Error 插件中断请求时返回，Status为返回给客户端的状态码
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, e.Message)
+}
+
+func Reject(status int, message string) error {
+	return &Error{Status: status, Message: message}
+}
+
+// ErrorWriter 需要自定义响应体的插件错误，比如以JSON返回的参数校验错误
+type ErrorWriter interface {
+	error
+	WriteTo(w http.ResponseWriter)
+}
+
+type registration struct {
+	name    string
+	phases  Phase
+	factory Factory
+}
+
+var registryMutex sync.RWMutex
+var registry = make(map[string]*registration)
+
+// Register 按名称注册插件，名称与LDS中Plugins.PluginName对应，重复注册会panic
+func Register(name string, phases Phase, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("plugin " + name + " already registered")
+	}
+
+	registry[name] = &registration{name: name, phases: phases, factory: factory}
+}
+
+// Registered 已注册的插件名称
+func Registered() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func lookup(name string) *registration {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	return registry[name]
+}
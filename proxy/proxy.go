@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/mgcicd/cicd-core/auth"
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/mirror"
+	"github.com/mgcicd/cicd-core/outlier"
+	"github.com/mgcicd/cicd-core/plugin"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+// Proxy 仅依赖Manager中的LDS/CDS配置转发HTTP请求，可作为不部署Envoy时的简易网关
+type Proxy struct {
+	// Transport 为空时使用http.DefaultTransport
+	Transport http.RoundTripper
+	// Outlier 不为空时上报每个请求的结果用于异常点检测
+	Outlier *outlier.Detector
+	// Mirror 不为空时按路由的MirrorPolicy把请求复制到影子集群
+	Mirror *mirror.Mirror
+	// Plugins 不为空时按LDS.Plugins执行插件链
+	Plugins *plugin.Chains
+
+	manager *common.Manager
+	table   atomic.Value
+	stop    chan struct{}
+}
+
+func New(m *common.Manager) *Proxy {
+	p := &Proxy{
+		manager: m,
+		stop:    make(chan struct{}),
+	}
+
+	lds := m.Subscribe("/lds/")
+	cds := m.Subscribe("/cds/")
+
+	p.Reload()
+
+	go p.watch(lds, cds)
+
+	return p
+}
+
+// Reload 从Manager重新构建路由表并整体替换
+func (p *Proxy) Reload() {
+	p.table.Store(buildRouteTable(p.manager.GetAllLds(), p.manager.GetAllCds()))
+}
+
+func (p *Proxy) Close() {
+	close(p.stop)
+}
+
+// watch 通过Subscribe监听变更，不会抢占其他地方对LdsChangedEvent/CdsChangedEvent的消费
+func (p *Proxy) watch(lds <-chan common.ChangedEvent, cds <-chan common.ChangedEvent) {
+	for {
+		select {
+		case <-lds:
+			p.Reload()
+		case <-cds:
+			p.Reload()
+		case <-p.stop:
+			p.manager.Unsubscribe(lds)
+			p.manager.Unsubscribe(cds)
+			return
+		}
+	}
+}
+
+func (p *Proxy) current() *routeTable {
+	return p.table.Load().(*routeTable)
+}
+
+// Lookup 返回请求命中的LDS和路由，未命中时返回nil
+func (p *Proxy) Lookup(r *http.Request) (*envoy.LDS, *envoy.HTTPRoute) {
+	entry := p.current().match(r.Host, r.URL.Path)
+	if entry == nil {
+		return nil, nil
+	}
+
+	return entry.lds, entry.route
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	table := p.current()
+
+	entry := table.match(r.Host, r.URL.Path)
+	if entry == nil {
+		http.Error(w, "route not found", http.StatusNotFound)
+		return
+	}
+
+	eds := table.clusters[entry.route.ClusterName]
+	if eds == nil {
+		http.Error(w, "cluster not found", http.StatusServiceUnavailable)
+		return
+	}
+
+	var chain *plugin.RouteChain
+	var pluginCtx *plugin.Context
+	if p.Plugins != nil {
+		chain = p.Plugins.Get(entry.lds).Route(entry.route)
+		pluginCtx = &plugin.Context{LDS: entry.lds, Route: entry.route, Request: r, Values: make(map[string]interface{})}
+
+		if err := chain.OnRequest(pluginCtx); err != nil {
+			plugin.WriteError(w, err)
+			return
+		}
+		r = pluginCtx.Request
+	}
+
+	endpoint, err := p.selectEndpoint(eds, entry.route, r, pluginCtx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	target, err := url.Parse(endpoint.ToString())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if p.Mirror != nil {
+		if policy := entry.lds.GetMirror(entry.route); policy != nil {
+			p.Mirror.Send(policy, table.clusters[policy.ClusterName], r, entry.rewritePath(r.URL.Path))
+		}
+	}
+
+	if timeout := entry.route.GetTimeOut(); timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	reverseProxy := &httputil.ReverseProxy{
+		Director: func(out *http.Request) {
+			out.URL.Scheme = target.Scheme
+			out.URL.Host = target.Host
+			out.URL.Path = entry.rewritePath(out.URL.Path)
+			out.URL.RawPath = ""
+
+			if entry.route.HostRewrite {
+				out.Host = target.Host
+			}
+		},
+		Transport: p.Transport,
+		ModifyResponse: func(resp *http.Response) error {
+			p.report(eds, endpoint, resp.StatusCode)
+
+			if chain != nil {
+				pluginCtx.Response = resp
+				if err := chain.OnResponse(pluginCtx); err != nil {
+					return &responsePluginError{err: err}
+				}
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			//上游已正常响应并上报过，插件的错误不计入异常点检测
+			var pluginErr *responsePluginError
+			if errors.As(err, &pluginErr) {
+				plugin.WriteError(w, pluginErr.err)
+				return
+			}
+
+			log.Printf("proxy: %s %s failed: %v\n", r.Method, r.URL.String(), err)
+
+			status := http.StatusBadGateway
+			if r.Context().Err() == context.DeadlineExceeded {
+				status = http.StatusGatewayTimeout
+			}
+
+			p.report(eds, endpoint, status)
+			w.WriteHeader(status)
+		},
+	}
+
+	reverseProxy.ServeHTTP(w, r)
+}
+
+// selectEndpoint 路由开启BindInternalUsers时内部用户访问BindInternalUserTag版本，该版本没有可用实例时按默认轮询
+// 用户取认证插件写入的Principal，没有时guid从请求中读取
+// 请求中的guid由客户端控制，只作为路由提示，不能用于访问控制；需要限制访问时应在路由上启用认证插件
+func (p *Proxy) selectEndpoint(eds *envoy.EDS, route *envoy.HTTPRoute, r *http.Request, ctx *plugin.Context) (*envoy.Endpoint, error) {
+	if route.BindInternalUsers {
+		var userId, guid string
+		if ctx != nil {
+			if principal, ok := ctx.Values[auth.PrincipalKey].(*auth.Principal); ok {
+				userId, guid = principal.UserId, principal.Guid
+			}
+		}
+		if guid == "" {
+			guid = r.Header.Get(route.GetGuIdKey())
+		}
+		if guid == "" {
+			guid = util.GetUrlQueryStringByLastOne(route.GetGuIdKey(), r.URL.Query())
+		}
+
+		if tag, ok := p.manager.GetInternalUsers().RouteTag(route, userId, guid); ok {
+			if endpoint, err := eds.GetVersionEndpoint(tag); err == nil {
+				return endpoint, nil
+			}
+		}
+	}
+
+	return eds.GetEndpoint()
+}
+
+// responsePluginError PhaseResponse阶段插件返回的错误，与转发失败区分
+type responsePluginError struct {
+	err error
+}
+
+func (e *responsePluginError) Error() string {
+	return e.err.Error()
+}
+
+func (p *Proxy) report(eds *envoy.EDS, endpoint *envoy.Endpoint, statusCode int) {
+	if p.Outlier != nil {
+		p.Outlier.Report(eds, endpoint, statusCode)
+	}
+}
@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/outlier"
+	"github.com/mgcicd/cicd-core/plugin"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func newBackend(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *envoy.Endpoint) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	return server, &envoy.Endpoint{Ip: host, Port: p, Name: "pod-1"}
+}
+
+func TestProxy_ServeHTTP(t *testing.T) {
+	var gotPath, gotHost string
+	_, endpoint := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotHost = r.Host
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	m := &common.Manager{}
+	m.SetCache("/cds/user", util.StructToJson(&envoy.EDS{Name: "user", Endpoints: []*envoy.Endpoint{endpoint}}))
+	m.SetCache("/lds/user", util.StructToJson(&envoy.LDS{
+		Name:           "user",
+		RouteMatchType: envoy.Prefix,
+		Listeners: []*envoy.Listener{{
+			Domains: []string{"api.example.com"},
+			Routes: []*envoy.HTTPRoute{
+				{Prefix: "/user/", PrefixRewrite: "/", ClusterName: "user"},
+				{Prefix: "/user/admin/", PrefixRewrite: "/admin/", ClusterName: "user", HostRewrite: true},
+			},
+		}},
+	}))
+
+	p := New(m)
+	defer p.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/user/info", nil)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	body, _ := ioutil.ReadAll(rec.Body)
+	if rec.Code != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("unexpected response %d %s", rec.Code, body)
+	}
+	if gotPath != "/info" || gotHost != "api.example.com" {
+		t.Fatalf("unexpected upstream request %s %s", gotHost, gotPath)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://api.example.com/user/admin/list", nil)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if gotPath != "/admin/list" || gotHost == "api.example.com" {
+		t.Fatalf("unexpected upstream request %s %s", gotHost, gotPath)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://other.example.com/user/info", nil)
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestProxy_RegexRewrite(t *testing.T) {
+	var gotPath string
+	_, endpoint := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+	})
+
+	m := &common.Manager{}
+	m.SetCache("/cds/order", util.StructToJson(&envoy.EDS{Name: "order", Endpoints: []*envoy.Endpoint{endpoint}}))
+	m.SetCache("/lds/order", util.StructToJson(&envoy.LDS{
+		Name:           "order",
+		RouteMatchType: envoy.Regex,
+		Listeners: []*envoy.Listener{{
+			Domains: []string{"*"},
+			Routes:  []*envoy.HTTPRoute{{Prefix: `/order/(\d+)/detail`, PrefixRewrite: `/orders/\1`, ClusterName: "order"}},
+		}},
+	}))
+
+	p := New(m)
+	defer p.Close()
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://api.example.com/order/42/detail", nil))
+	if rec.Code != http.StatusOK || gotPath != "/orders/42" {
+		t.Fatalf("unexpected upstream path %d %s", rec.Code, gotPath)
+	}
+}
+
+func TestProxy_TimeOut(t *testing.T) {
+	_, endpoint := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(3 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+
+	m := &common.Manager{}
+	m.SetCache("/cds/slow", util.StructToJson(&envoy.EDS{Name: "slow", Endpoints: []*envoy.Endpoint{endpoint}}))
+	m.SetCache("/lds/slow", util.StructToJson(&envoy.LDS{
+		RouteMatchType: envoy.Path,
+		Listeners: []*envoy.Listener{{
+			Domains: []string{"*"},
+			Routes:  []*envoy.HTTPRoute{{Prefix: "/slow", ClusterName: "slow", TimeOut: 1}},
+		}},
+	}))
+
+	p := New(m)
+	defer p.Close()
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://any/slow", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", rec.Code)
+	}
+}
+
+func TestProxy_Reload(t *testing.T) {
+	_, endpoint := newBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	m := &common.Manager{}
+	m.SetCache("/cds/a", util.StructToJson(&envoy.EDS{Name: "a", Endpoints: []*envoy.Endpoint{endpoint}}))
+
+	p := New(m)
+	defer p.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "http://any/a", nil)
+	if _, route := p.Lookup(req); route != nil {
+		t.Fatal("expected no route before lds is set")
+	}
+
+	m.SetCache("/lds/a", util.StructToJson(&envoy.LDS{
+		RouteMatchType: envoy.Regex,
+		Listeners: []*envoy.Listener{{
+			Domains: []string{"*"},
+			Routes:  []*envoy.HTTPRoute{{Prefix: "/[a-z]", ClusterName: "a"}},
+		}},
+	}))
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, route := p.Lookup(req); route != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("route table was not reloaded")
+}
+
+func TestProxy_InternalUsers(t *testing.T) {
+	_, prod := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("prod"))
+	})
+	_, canary := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("canary"))
+	})
+	prod.Version = "prod"
+	canary.Version = "canary"
+
+	m := &common.Manager{}
+	m.SetCache("/cds/order", util.StructToJson(&envoy.EDS{Name: "order", Endpoints: []*envoy.Endpoint{prod}}))
+	m.SetCache("/lds/order", util.StructToJson(&envoy.LDS{
+		RouteMatchType: envoy.Prefix,
+		Listeners: []*envoy.Listener{{
+			Domains: []string{"*"},
+			Routes:  []*envoy.HTTPRoute{{Prefix: "/", ClusterName: "order", BindInternalUsers: true, BindInternalUserTag: "canary"}},
+		}},
+	}))
+	m.SetCache(common.InternalUsersPath, util.StructToJson(&envoy.InternalUsers{Users: []*envoy.InternalUser{{Guid: "g-1", Status: util.Yes}}}))
+
+	p := New(m)
+	defer p.Close()
+
+	get := func(guid string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://any/order?guid="+guid, nil)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		body, _ := ioutil.ReadAll(rec.Body)
+		return string(body)
+	}
+
+	if body := get("g-1"); body != "prod" {
+		t.Fatalf("internal user should fall back to prod without canary endpoints, got %s", body)
+	}
+
+	m.SetCache("/cds/order", util.StructToJson(&envoy.EDS{Name: "order", Endpoints: []*envoy.Endpoint{prod, canary}}))
+	p.Reload()
+
+	for i := 0; i < 4; i++ {
+		if body := get("g-1"); body != "canary" {
+			t.Fatalf("internal user should route to canary, got %s", body)
+		}
+	}
+	if users := m.GetInternalUsers(); len(users.Users) != 1 {
+		t.Fatalf("unexpected internal users %+v", users)
+	}
+}
+
+type rejectResponse struct{}
+
+func (rejectResponse) OnResponse(ctx *plugin.Context) error {
+	return plugin.Reject(http.StatusForbidden, "response rejected")
+}
+
+func TestProxy_ResponsePluginError(t *testing.T) {
+	plugin.Register("proxy-test-reject-response", plugin.PhaseResponse, func(lds *envoy.LDS, route *envoy.HTTPRoute) (plugin.Plugin, error) {
+		return rejectResponse{}, nil
+	})
+
+	_, endpoint := newBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	m := &common.Manager{}
+	m.SetCache("/cds/user", util.StructToJson(&envoy.EDS{
+		Name:                   "user",
+		Endpoints:              []*envoy.Endpoint{endpoint},
+		EnableOutlierDetection: util.True,
+		OutlierDetection:       envoy.OutlierDetection{Consecutive_5Xx: 1, MaxEjectionPercent: 100},
+	}))
+	m.SetCache("/lds/user", util.StructToJson(&envoy.LDS{
+		Name:           "user",
+		RouteMatchType: envoy.Prefix,
+		Plugins:        []*envoy.Plugins{{PluginName: "proxy-test-reject-response"}},
+		Listeners: []*envoy.Listener{{
+			Domains: []string{"api.example.com"},
+			Routes:  []*envoy.HTTPRoute{{Prefix: "/", ClusterName: "user"}},
+		}},
+	}))
+
+	p := New(m)
+	defer p.Close()
+	p.Outlier = outlier.NewDetector()
+	p.Plugins = plugin.NewChains(m)
+	defer p.Plugins.Close()
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://api.example.com/", nil))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("plugin status should be returned, got %d", rec.Code)
+		}
+	}
+
+	if p.Outlier.Ejected(m.GetAllCds()["user"], endpoint) {
+		t.Fatal("plugin errors should not be reported as upstream failures")
+	}
+}
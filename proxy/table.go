@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+type routeEntry struct {
+	lds       *envoy.LDS
+	route     *envoy.HTTPRoute
+	matchType envoy.RouteMatchType
+	regex     *regexp.Regexp
+}
+
+// routeTable 是某一时刻LDS/CDS的只读快照，变更时整体替换
+type routeTable struct {
+	domains  map[string][]*routeEntry
+	clusters map[string]*envoy.EDS
+}
+
+func buildRouteTable(ldsData map[string]*envoy.LDS, cdsData map[string]*envoy.EDS) *routeTable {
+	table := &routeTable{
+		domains:  make(map[string][]*routeEntry),
+		clusters: make(map[string]*envoy.EDS),
+	}
+
+	// 按路径排序，保证多个LDS声明同一域名时匹配顺序稳定
+	paths := make([]string, 0, len(ldsData))
+	for path := range ldsData {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		lds := ldsData[path]
+
+		for _, listener := range lds.Listeners {
+			if listener == nil {
+				continue
+			}
+
+			for _, route := range listener.Routes {
+				if route == nil {
+					continue
+				}
+
+				entry := &routeEntry{
+					lds:       lds,
+					route:     route,
+					matchType: lds.RouteMatchType,
+				}
+
+				if entry.matchType == envoy.Regex {
+					regex, err := regexp.Compile("^(?:" + route.Prefix + ")$")
+					if err != nil {
+						log.Printf("proxy: %s route %s invalid regex: %v\n", path, route.Prefix, err)
+						continue
+					}
+					entry.regex = regex
+				}
+
+				for _, domain := range listener.Domains {
+					domain = strings.ToLower(domain)
+					table.domains[domain] = append(table.domains[domain], entry)
+				}
+			}
+		}
+	}
+
+	for name, eds := range cdsData {
+		table.clusters[name] = eds
+		if eds.Name != "" {
+			table.clusters[eds.Name] = eds
+		}
+	}
+
+	return table
+}
+
+// match 按域名找到候选路由后依次尝试：完全匹配的Path路由，最长的Prefix路由，第一个命中的Regex路由
+func (t *routeTable) match(host string, path string) *routeEntry {
+	entries := t.lookupDomain(host)
+
+	var prefixMatched *routeEntry
+
+	for _, entry := range entries {
+		switch entry.matchType {
+		case envoy.Path:
+			if path == entry.route.Prefix {
+				return entry
+			}
+		case envoy.Prefix:
+			if strings.HasPrefix(path, entry.route.Prefix) &&
+				(prefixMatched == nil || len(entry.route.Prefix) > len(prefixMatched.route.Prefix)) {
+				prefixMatched = entry
+			}
+		}
+	}
+
+	if prefixMatched != nil {
+		return prefixMatched
+	}
+
+	for _, entry := range entries {
+		if entry.matchType == envoy.Regex && entry.regex.MatchString(path) {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// lookupDomain 域名匹配顺序：完全匹配，最长的"*.xxx"后缀匹配，"*"
+func (t *routeTable) lookupDomain(host string) []*routeEntry {
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if entries, ok := t.domains[host]; ok {
+		return entries
+	}
+
+	var matched []*routeEntry
+	var matchedLen int
+
+	for domain, entries := range t.domains {
+		if !strings.HasPrefix(domain, "*.") {
+			continue
+		}
+
+		if strings.HasSuffix(host, domain[1:]) && len(domain) > matchedLen {
+			matched = entries
+			matchedLen = len(domain)
+		}
+	}
+
+	if matched != nil {
+		return matched
+	}
+
+	return t.domains["*"]
+}
+
+// envoySubstitution Regex路由的PrefixRewrite与Envoy regex_rewrite一致，使用\1引用分组
+var envoySubstitution = regexp.MustCompile(`\\(\d+)`)
+
+func (entry *routeEntry) rewritePath(path string) string {
+	rewrite := entry.route.PrefixRewrite
+	if rewrite == "" {
+		return path
+	}
+
+	switch entry.matchType {
+	case envoy.Prefix:
+		return rewrite + strings.TrimPrefix(path, entry.route.Prefix)
+	case envoy.Regex:
+		return entry.regex.ReplaceAllString(path, envoySubstitution.ReplaceAllString(rewrite, "$${$1}"))
+	default:
+		return rewrite
+	}
+}
@@ -0,0 +1,230 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mgcicd/cicd-core/breaker"
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+// Transport 按路由的TimeOutPolicy和RetryPolicy发送请求的http.RoundTripper
+// 总超时覆盖所有尝试和重试间隔，每次尝试另受PerTryTimeOutMs限制；重试用完后返回最后一次的响应或错误
+type Transport struct {
+	Route *envoy.HTTPRoute
+	// Breaker 不为空时每次重试占用一个Retry资源，集群开启熔断时并发重试数受CircuitBreaker.MaxRetries限制
+	Breaker *breaker.Breaker
+	// Base 为空时使用http.DefaultTransport
+	Base http.RoundTripper
+
+	active  int64 //进行中的请求数
+	retries int64 //进行中的重试数
+}
+
+// NewClient 使用Transport的http.Client，超时由Transport控制
+func NewClient(route *envoy.HTTPRoute, b *breaker.Breaker, base http.RoundTripper) *http.Client {
+	return &http.Client{Transport: &Transport{Route: route, Breaker: b, Base: base}}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	var ctx context.Context
+	var cancelTotal context.CancelFunc
+	if timeout := t.Route.GetTimeOut(); timeout > 0 {
+		ctx, cancelTotal = context.WithTimeout(req.Context(), timeout)
+	} else {
+		ctx, cancelTotal = context.WithCancel(req.Context())
+	}
+
+	var policy *envoy.RetryPolicy
+	if t.Route != nil {
+		policy = t.Route.Retry
+	}
+
+	getBody, err := rewindable(req, policy.GetNumRetries() > 0)
+	if err != nil {
+		cancelTotal()
+		return nil, err
+	}
+
+	atomic.AddInt64(&t.active, 1)
+	defer atomic.AddInt64(&t.active, -1)
+
+	release := func() {}
+
+	for attempt := uint32(0); ; attempt++ {
+		resp, cancelTry, err := t.try(ctx, req, getBody, base)
+		release()
+
+		if policy == nil || attempt >= policy.GetNumRetries() || ctx.Err() != nil || !policy.ShouldRetry(statusCode(resp), err) {
+			return finish(resp, err, func() {
+				cancelTry()
+				cancelTotal()
+			})
+		}
+
+		var ok bool
+		if release, ok = t.acquireRetry(policy); !ok {
+			return finish(resp, err, func() {
+				cancelTry()
+				cancelTotal()
+			})
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancelTry()
+
+		if !sleep(ctx, backoff(policy, attempt)) {
+			release()
+			cancelTotal()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (t *Transport) try(ctx context.Context, req *http.Request, getBody func() (io.ReadCloser, error), base http.RoundTripper) (*http.Response, context.CancelFunc, error) {
+	var tryCtx context.Context
+	var cancel context.CancelFunc
+	if timeout := t.Route.GetPerTryTimeOut(); timeout > 0 {
+		tryCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		tryCtx, cancel = context.WithCancel(ctx)
+	}
+
+	out := req.Clone(tryCtx)
+	if getBody != nil {
+		body, err := getBody()
+		if err != nil {
+			return nil, cancel, err
+		}
+		out.Body = body
+	}
+
+	resp, err := base.RoundTrip(out)
+	return resp, cancel, err
+}
+
+// acquireRetry 重试预算和熔断器都允许时占用一次并发重试
+func (t *Transport) acquireRetry(policy *envoy.RetryPolicy) (func(), bool) {
+	n := atomic.AddInt64(&t.retries, 1)
+
+	if policy.BudgetPercent > 0 {
+		allowed := int64(float64(atomic.LoadInt64(&t.active)) * policy.BudgetPercent / 100)
+		if minimum := int64(policy.GetMinRetryConcurrency()); allowed < minimum {
+			allowed = minimum
+		}
+		if n > allowed {
+			atomic.AddInt64(&t.retries, -1)
+			return nil, false
+		}
+	}
+
+	releaseBreaker := func() {}
+	if t.Breaker != nil {
+		var err error
+		if releaseBreaker, err = t.Breaker.Acquire(breaker.Retry); err != nil {
+			atomic.AddInt64(&t.retries, -1)
+			return nil, false
+		}
+	}
+
+	return func() {
+		releaseBreaker()
+		atomic.AddInt64(&t.retries, -1)
+	}, true
+}
+
+// rewindable 需要重试时返回每次尝试重新读取请求体的函数，不需要重试或没有请求体时返回nil
+func rewindable(req *http.Request, retry bool) (func() (io.ReadCloser, error), error) {
+	if !retry || req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		return req.GetBody, nil
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}, nil
+}
+
+func statusCode(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// backoff 第attempt次重试前的等待时间，取[0, min(BaseInterval*2^attempt, MaxInterval))内的随机值
+func backoff(policy *envoy.RetryPolicy, attempt uint32) time.Duration {
+	interval := policy.GetBaseInterval()
+	maxInterval := policy.GetMaxInterval()
+
+	for i := uint32(0); i < attempt && interval < maxInterval; i++ {
+		interval *= 2
+	}
+	if interval > maxInterval {
+		interval = maxInterval
+	}
+	if interval <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(interval)))
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// finish 响应body关闭时才取消超时的context，否则调用方读取body时会被中断
+func finish(resp *http.Response, err error, cancel func()) (*http.Response, error) {
+	if err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
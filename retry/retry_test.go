@@ -0,0 +1,154 @@
+package retry
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mgcicd/cicd-core/breaker"
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func TestTransport_Retry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	route := &envoy.HTTPRoute{Retry: &envoy.RetryPolicy{RetryOn: []envoy.RetryOn{envoy.RetryOnGatewayError}, NumRetries: 2, BaseInterval: 1}}
+	client := NewClient(route, nil, nil)
+
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "payload" || calls != 3 {
+		t.Fatalf("unexpected response %d %s after %d calls", resp.StatusCode, body, calls)
+	}
+}
+
+func TestTransport_NotRetryable(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	route := &envoy.HTTPRoute{Retry: &envoy.RetryPolicy{RetryOn: []envoy.RetryOn{envoy.RetryOnGatewayError}, NumRetries: 3}}
+
+	resp, err := NewClient(route, nil, nil).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError || calls != 1 {
+		t.Fatalf("500 should not be retried on gateway-error, got %d after %d calls", resp.StatusCode, calls)
+	}
+}
+
+func TestTransport_NoRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	route := &envoy.HTTPRoute{Retry: &envoy.RetryPolicy{RetryOn: []envoy.RetryOn{envoy.RetryOnGatewayError}}}
+
+	resp, err := NewClient(route, nil, nil).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway || calls != 1 {
+		t.Fatalf("NumRetries 0 should not retry, got %d after %d calls", resp.StatusCode, calls)
+	}
+}
+
+func TestTransport_PerTryTimeOut(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	route := &envoy.HTTPRoute{
+		TimeOutPolicy: &envoy.TimeOutPolicy{TimeOutMs: 500, PerTryTimeOutMs: 50},
+		Retry:         &envoy.RetryPolicy{RetryOn: []envoy.RetryOn{envoy.RetryOnConnectFailure}, NumRetries: 1, BaseInterval: 1},
+	}
+
+	resp, err := NewClient(route, nil, nil).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "ok" || calls != 2 {
+		t.Fatalf("unexpected response %s after %d calls", body, calls)
+	}
+}
+
+func TestTransport_BreakerMaxRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	m := &common.Manager{}
+	m.SetCache("/cds/order", util.StructToJson(&envoy.EDS{
+		Name:                 "order",
+		EnableCircuitbreaker: int(util.True),
+		CircuitBreaker:       []*envoy.CircuitBreaker{{MaxRetries: 1}},
+	}))
+
+	registry := breaker.NewRegistry(m)
+	defer registry.Close()
+
+	b := registry.Get("order")
+	release, err := b.Acquire(breaker.Retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	route := &envoy.HTTPRoute{Retry: &envoy.RetryPolicy{NumRetries: 3}}
+
+	resp, err := NewClient(route, b, nil).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway || calls != 1 {
+		t.Fatalf("retry should be rejected by the breaker, got %d after %d calls", resp.StatusCode, calls)
+	}
+}
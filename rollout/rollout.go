@@ -0,0 +1,432 @@
+package rollout
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+// PathPrefix 灰度计划的存储位置，/config/rollout/{cluster}
+const PathPrefix = "/config/rollout/"
+
+type State string
+
+const (
+	StatePending     State = "Pending"     //已创建，下次调度时切到第一步
+	StateProgressing State = "Progressing" //按步骤推进中
+	StatePaused      State = "Paused"      //门禁失败或手动暂停，Resume后继续
+	StateSucceeded   State = "Succeeded"   //最后一步已通过门禁
+	StateRolledBack  State = "RolledBack"  //灰度版本权重已回到0
+)
+
+// Plan 一次灰度发布的配置和进度，写在/config/rollout/{cluster}中，进程重启后从当前步骤继续
+type Plan struct {
+	Cluster       string
+	Version       string   //灰度版本，对应EDS_Version.Version
+	StableVersion string   //稳定版本，得到100减去灰度权重的流量
+	Steps         []int    //灰度版本依次使用的权重，比如[5,25,50,100]
+	Interval      int      //每一步至少停留的时间(秒)
+	Gates         []string //进入下一步前需要通过的门禁，对应Options.Gates中的名称
+	AutoRollback  bool     //门禁失败时自动回滚，否则暂停
+
+	State         State
+	Step          int   //当前步骤在Steps中的下标
+	StepStartedAt int64 //当前步骤开始的时间(unix秒)
+	Message       string
+	Revision      int64 //每次写入加1，用于识别缓存中的旧数据
+}
+
+func (p *Plan) Validate() error {
+	if p.Cluster == "" || strings.Contains(p.Cluster, "/") {
+		return errors.New("invalid cluster")
+	}
+	if p.Version == "" || p.StableVersion == "" || p.Version == p.StableVersion {
+		return errors.New("version and stable version are required and must differ")
+	}
+	if len(p.Steps) == 0 {
+		return errors.New("steps are required")
+	}
+
+	last := 0
+	for _, w := range p.Steps {
+		if w <= last || w > 100 {
+			return fmt.Errorf("steps must be increasing within (0, 100], got %v", p.Steps)
+		}
+		last = w
+	}
+
+	return nil
+}
+
+// Weight 当前步骤灰度版本的权重
+func (p *Plan) Weight() int {
+	switch p.State {
+	case StatePending, StateRolledBack:
+		return 0
+	}
+	if p.Step < 0 || p.Step >= len(p.Steps) {
+		return 0
+	}
+	return p.Steps[p.Step]
+}
+
+// ErrGateWait 门禁暂时无法判断(比如数据不足)，保持当前步骤，下次调度时再检查
+var ErrGateWait = errors.New("gate is not ready")
+
+// Gate 步骤之间的健康检查，返回nil表示通过，ErrGateWait表示等待，其余错误表示失败
+type Gate interface {
+	Check(plan *Plan, eds *envoy.EDS) error
+}
+
+type GateFunc func(plan *Plan, eds *envoy.EDS) error
+
+func (f GateFunc) Check(plan *Plan, eds *envoy.EDS) error {
+	return f(plan, eds)
+}
+
+// EndpointsGate 灰度版本至少有一个启用的endpoint
+var EndpointsGate = GateFunc(func(plan *Plan, eds *envoy.EDS) error {
+	for _, ep := range eds.Endpoints {
+		if ep != nil && ep.Version == plan.Version && ep.Status != util.No {
+			return nil
+		}
+	}
+	return errors.New("no endpoints for version " + plan.Version)
+})
+
+// Store 读写灰度计划和/cds节点，*common.Manager实现了该接口
+type Store interface {
+	Get(path string) interface{}
+	GetChildren(path string) []string
+	GetAllCds() map[string]*envoy.EDS
+	Exists(path string) bool
+	Create(name string, v interface{}) error
+	Set(path string, v interface{}) error
+}
+
+type Options struct {
+	Gates map[string]Gate
+	// Now 为空时使用time.Now
+	Now func() time.Time
+}
+
+func (o Options) withDefaults() Options {
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+	return o
+}
+
+// Controller 按计划推进EDS_Version的FlowWeight，同一集群同时只能有一个计划
+type Controller struct {
+	store Store
+	opts  Options
+
+	mu      sync.Mutex
+	written map[string]*Plan //最近写入的计划，缓存还未收到变更时以此为准
+}
+
+func NewController(store Store, opts Options) *Controller {
+	return &Controller{
+		store:   store,
+		opts:    opts.withDefaults(),
+		written: make(map[string]*Plan),
+	}
+}
+
+// Run 每隔interval调度一次，直到stop关闭
+func (c *Controller) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Reconcile(); err != nil {
+			log.Println("rollout reconcile failed:", err.Error())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Plan 读取集群的灰度计划，不存在时返回nil
+func (c *Controller) Plan(cluster string) *Plan {
+	path := PathPrefix + cluster
+
+	plan := &Plan{}
+	value, _ := c.store.Get(path).(string)
+	if value == "" || util.JsonToStruct(value, plan) != nil || plan.Cluster == "" {
+		plan = nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if written := c.written[path]; written != nil && (plan == nil || written.Revision > plan.Revision) {
+		copied := *written
+		return &copied
+	}
+
+	return plan
+}
+
+func (c *Controller) save(plan *Plan) error {
+	path := PathPrefix + plan.Cluster
+	plan.Revision++
+
+	var err error
+	if c.store.Exists(path) {
+		err = c.store.Set(path, plan)
+	} else {
+		err = c.store.Create(path, plan)
+	}
+	if err != nil {
+		return err
+	}
+
+	copied := *plan
+	c.mu.Lock()
+	c.written[path] = &copied
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Start 创建灰度计划，集群已有未结束的计划时返回错误
+func (c *Controller) Start(plan *Plan) error {
+	if err := plan.Validate(); err != nil {
+		return err
+	}
+
+	if old := c.Plan(plan.Cluster); old != nil {
+		if old.State != StateSucceeded && old.State != StateRolledBack {
+			return errors.New("rollout of " + plan.Cluster + " is in progress")
+		}
+		plan.Revision = old.Revision
+	}
+
+	plan.State = StatePending
+	plan.Step = 0
+	plan.StepStartedAt = 0
+	plan.Message = ""
+
+	return c.save(plan)
+}
+
+func (c *Controller) transition(cluster string, from []State, to State, message string) error {
+	plan := c.Plan(cluster)
+	if plan == nil {
+		return errors.New("no rollout for " + cluster)
+	}
+
+	for _, state := range from {
+		if plan.State == state {
+			if to == StateRolledBack {
+				return c.rollback(plan, message)
+			}
+			plan.State = to
+			plan.Message = message
+			if to == StateProgressing {
+				plan.StepStartedAt = c.opts.Now().Unix()
+			}
+			return c.save(plan)
+		}
+	}
+
+	return fmt.Errorf("rollout of %s is %s", cluster, plan.State)
+}
+
+func (c *Controller) Pause(cluster string, reason string) error {
+	return c.transition(cluster, []State{StatePending, StateProgressing}, StatePaused, reason)
+}
+
+// Resume 继续暂停的计划，当前步骤重新计时
+func (c *Controller) Resume(cluster string) error {
+	return c.transition(cluster, []State{StatePaused}, StateProgressing, "")
+}
+
+// Rollback 灰度版本权重置0，稳定版本权重置100
+func (c *Controller) Rollback(cluster string, reason string) error {
+	return c.transition(cluster, []State{StatePending, StateProgressing, StatePaused, StateSucceeded}, StateRolledBack, reason)
+}
+
+// Reconcile 调度所有计划一次，返回状态或步骤发生变化的集群
+func (c *Controller) Reconcile() ([]string, error) {
+	var changed []string
+	var errs []string
+
+	for _, cluster := range c.store.GetChildren(strings.TrimSuffix(PathPrefix, "/")) {
+		plan := c.Plan(cluster)
+		if plan == nil {
+			continue
+		}
+
+		step := plan.Step
+		state := plan.State
+
+		if err := c.reconcile(plan); err != nil {
+			errs = append(errs, cluster+": "+err.Error())
+			continue
+		}
+
+		if plan.State != state || plan.Step != step {
+			changed = append(changed, cluster)
+		}
+	}
+
+	if len(errs) > 0 {
+		return changed, errors.New(strings.Join(errs, "; "))
+	}
+	return changed, nil
+}
+
+func (c *Controller) reconcile(plan *Plan) error {
+	now := c.opts.Now().Unix()
+
+	switch plan.State {
+	case StatePending:
+		if err := plan.Validate(); err != nil {
+			plan.State = StatePaused
+			plan.Message = err.Error()
+			return c.save(plan)
+		}
+
+		plan.State = StateProgressing
+		plan.Step = 0
+		plan.StepStartedAt = now
+		if err := c.apply(plan); err != nil {
+			return err
+		}
+		return c.save(plan)
+
+	case StateProgressing:
+		if now-plan.StepStartedAt < int64(plan.Interval) {
+			return nil
+		}
+
+		eds := c.cluster(plan.Cluster)
+		if eds == nil {
+			return errors.New("cluster not found")
+		}
+
+		if err := c.check(plan, eds); err != nil {
+			if err == ErrGateWait {
+				return nil
+			}
+			if plan.AutoRollback {
+				return c.rollback(plan, err.Error())
+			}
+			plan.State = StatePaused
+			plan.Message = err.Error()
+			return c.save(plan)
+		}
+
+		if plan.Step >= len(plan.Steps)-1 {
+			plan.State = StateSucceeded
+			plan.Message = ""
+			return c.save(plan)
+		}
+
+		plan.Step++
+		plan.StepStartedAt = now
+		if err := c.apply(plan); err != nil {
+			return err
+		}
+		return c.save(plan)
+	}
+
+	return nil
+}
+
+// check 依次检查门禁，任一门禁等待或失败时返回
+func (c *Controller) check(plan *Plan, eds *envoy.EDS) error {
+	for _, name := range plan.Gates {
+		gate := c.opts.Gates[name]
+		if gate == nil {
+			return errors.New("gate " + name + " not found")
+		}
+
+		if err := gate.Check(plan, eds); err != nil {
+			if err == ErrGateWait {
+				return err
+			}
+			return fmt.Errorf("gate %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) rollback(plan *Plan, message string) error {
+	plan.State = StateRolledBack
+	plan.Message = message
+	if err := c.apply(plan); err != nil {
+		return err
+	}
+	return c.save(plan)
+}
+
+// findCluster 按节点名或EDS.Name查找集群，返回节点名和集群
+func (c *Controller) findCluster(name string) (string, *envoy.EDS) {
+	all := c.store.GetAllCds()
+	if eds, ok := all[name]; ok {
+		return name, eds
+	}
+	for node, eds := range all {
+		if eds.Name == name {
+			return node, eds
+		}
+	}
+	return "", nil
+}
+
+func (c *Controller) cluster(name string) *envoy.EDS {
+	_, eds := c.findCluster(name)
+	return eds
+}
+
+// apply 把计划当前的权重写入/cds，灰度版本和稳定版本都切换为按权重分流
+func (c *Controller) apply(plan *Plan) error {
+	node, old := c.findCluster(plan.Cluster)
+	if old == nil {
+		return errors.New("cluster not found")
+	}
+
+	eds := *old
+	eds.EDSVersions = SetWeight(old.EDSVersions, plan.Version, plan.StableVersion, plan.Weight())
+	eds.Version = eds.GetCdsVersion()
+
+	return c.store.Set("/cds/"+node, &eds)
+}
+
+// SetWeight 返回version权重为weight、stable权重为100-weight的EDS_Version副本，不存在的版本会被追加
+func SetWeight(versions []envoy.EDS_Version, version string, stable string, weight int) []envoy.EDS_Version {
+	result := make([]envoy.EDS_Version, 0, len(versions)+2)
+	result = append(result, versions...)
+
+	set := func(name string, w int) {
+		for i := range result {
+			if result[i].Version == name {
+				result[i].Enable = util.Yes
+				result[i].SelectPolicy = envoy.Policy_Weight
+				result[i].FlowWeight = w
+				return
+			}
+		}
+		result = append(result, envoy.EDS_Version{Version: name, Enable: util.Yes, SelectPolicy: envoy.Policy_Weight, FlowWeight: w})
+	}
+
+	set(version, weight)
+	set(stable, 100-weight)
+
+	return result
+}
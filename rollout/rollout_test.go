@@ -0,0 +1,188 @@
+package rollout
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+type fakeStore struct {
+	nodes map[string]string
+	cds   map[string]*envoy.EDS
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		nodes: make(map[string]string),
+		cds: map[string]*envoy.EDS{
+			"order": {
+				Name: "order",
+				Endpoints: []*envoy.Endpoint{
+					{Ip: "10.0.0.1", Version: "v1"},
+					{Ip: "10.0.0.2", Version: "v2"},
+				},
+				EDSVersions: []envoy.EDS_Version{{Version: "v1", Enable: util.Yes, FlowWeight: 100}},
+			},
+		},
+	}
+}
+
+func (s *fakeStore) Get(path string) interface{} {
+	return s.nodes[path]
+}
+
+func (s *fakeStore) GetChildren(path string) []string {
+	var children []string
+	for p := range s.nodes {
+		if strings.HasPrefix(p, path+"/") {
+			children = append(children, strings.TrimPrefix(p, path+"/"))
+		}
+	}
+	return children
+}
+
+func (s *fakeStore) GetAllCds() map[string]*envoy.EDS {
+	return s.cds
+}
+
+func (s *fakeStore) Exists(path string) bool {
+	_, ok := s.nodes[path]
+	return ok
+}
+
+func (s *fakeStore) Create(name string, v interface{}) error {
+	return s.Set(name, v)
+}
+
+func (s *fakeStore) Set(path string, v interface{}) error {
+	if strings.HasPrefix(path, "/cds/") {
+		eds := new(envoy.EDS)
+		util.JsonToStruct(util.StructToJson(v), eds)
+		s.cds[strings.TrimPrefix(path, "/cds/")] = eds
+		return nil
+	}
+	s.nodes[path] = util.StructToJson(v)
+	return nil
+}
+
+func weights(eds *envoy.EDS) map[string]int {
+	result := make(map[string]int)
+	for _, v := range eds.EDSVersions {
+		result[v.Version] = v.FlowWeight
+	}
+	return result
+}
+
+func TestController_Progress(t *testing.T) {
+	store := newFakeStore()
+	now := time.Unix(1000, 0)
+
+	var gateErr error
+	c := NewController(store, Options{
+		Gates: map[string]Gate{
+			"endpoints": EndpointsGate,
+			"errors": GateFunc(func(plan *Plan, eds *envoy.EDS) error {
+				return gateErr
+			}),
+		},
+		Now: func() time.Time { return now },
+	})
+
+	err := c.Start(&Plan{Cluster: "order", Version: "v2", StableVersion: "v1", Steps: []int{5, 50, 100}, Interval: 60, Gates: []string{"endpoints", "errors"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(&Plan{Cluster: "order", Version: "v2", StableVersion: "v1", Steps: []int{100}}); err == nil {
+		t.Fatal("expected error for a second rollout")
+	}
+
+	if _, err := c.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if w := weights(store.cds["order"]); w["v2"] != 5 || w["v1"] != 95 {
+		t.Fatalf("unexpected weights %v", w)
+	}
+
+	// 未到Interval时不推进
+	now = now.Add(30 * time.Second)
+	if changed, _ := c.Reconcile(); len(changed) != 0 {
+		t.Fatalf("unexpected change %v", changed)
+	}
+
+	gateErr = ErrGateWait
+	now = now.Add(60 * time.Second)
+	if changed, _ := c.Reconcile(); len(changed) != 0 {
+		t.Fatalf("waiting gate should keep the step, changed %v", changed)
+	}
+
+	gateErr = nil
+	c.Reconcile()
+	if w := weights(store.cds["order"]); w["v2"] != 50 || w["v1"] != 50 {
+		t.Fatalf("unexpected weights %v", w)
+	}
+
+	// 重启后从ZooKeeper中的进度继续
+	c = NewController(store, Options{Gates: c.opts.Gates, Now: func() time.Time { return now }})
+	if plan := c.Plan("order"); plan.State != StateProgressing || plan.Step != 1 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	now = now.Add(60 * time.Second)
+	c.Reconcile()
+	now = now.Add(60 * time.Second)
+	c.Reconcile()
+
+	if plan := c.Plan("order"); plan.State != StateSucceeded {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if w := weights(store.cds["order"]); w["v2"] != 100 || w["v1"] != 0 {
+		t.Fatalf("unexpected weights %v", w)
+	}
+}
+
+func TestController_GateFailure(t *testing.T) {
+	store := newFakeStore()
+	failing := GateFunc(func(plan *Plan, eds *envoy.EDS) error {
+		return errors.New("error rate too high")
+	})
+	c := NewController(store, Options{Gates: map[string]Gate{"errors": failing}})
+
+	plan := &Plan{Cluster: "order", Version: "v2", StableVersion: "v1", Steps: []int{10, 100}, Gates: []string{"errors"}}
+	if err := c.Start(plan); err != nil {
+		t.Fatal(err)
+	}
+	c.Reconcile()
+	c.Reconcile()
+
+	if plan := c.Plan("order"); plan.State != StatePaused || !strings.Contains(plan.Message, "error rate") {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if w := weights(store.cds["order"]); w["v2"] != 10 {
+		t.Fatalf("paused rollout should keep weights, got %v", w)
+	}
+
+	if err := c.Rollback("order", "manual"); err != nil {
+		t.Fatal(err)
+	}
+	if w := weights(store.cds["order"]); w["v2"] != 0 || w["v1"] != 100 {
+		t.Fatalf("unexpected weights after rollback %v", w)
+	}
+
+	plan.AutoRollback = true
+	if err := c.Start(plan); err != nil {
+		t.Fatal(err)
+	}
+	c.Reconcile()
+	c.Reconcile()
+
+	if plan := c.Plan("order"); plan.State != StateRolledBack {
+		t.Fatalf("expected auto rollback, got %+v", plan)
+	}
+	if w := weights(store.cds["order"]); w["v2"] != 0 || w["v1"] != 100 {
+		t.Fatalf("unexpected weights after auto rollback %v", w)
+	}
+}
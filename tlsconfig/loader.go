@@ -0,0 +1,352 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+)
+
+const (
+	filePrefix = "file:"
+	zkPrefix   = "zk:"
+
+	// ExpiryWarning 证书在该时间内过期时加载时打印告警
+	ExpiryWarning = 30 * 24 * time.Hour
+
+	fileCheckInterval = time.Minute
+)
+
+// Expiry 证书及其过期时间
+type Expiry struct {
+	Domains  []string
+	CertRef  string
+	NotAfter time.Time
+}
+
+type loadedCert struct {
+	domains []string
+	certRef string
+	config  *tls.Config
+	expiry  time.Time
+}
+
+// Loader 根据/lds中开启了EnableTLS的监听器构建*tls.Config，按SNI选择证书
+// 订阅/lds和证书所在的zk节点，变更时重新加载；file:证书每分钟检查一次修改时间
+// 重新加载失败的证书继续使用上一次成功加载的版本
+type Loader struct {
+	manager *common.Manager
+	mu      sync.RWMutex
+	domains map[string]*loadedCert
+	zkRefs  map[string]bool
+	files   map[string]time.Time
+	stop    chan struct{}
+}
+
+func NewLoader(m *common.Manager) *Loader {
+	l := &Loader{
+		manager: m,
+		domains: make(map[string]*loadedCert),
+		zkRefs:  make(map[string]bool),
+		files:   make(map[string]time.Time),
+		stop:    make(chan struct{}),
+	}
+
+	changes := m.Subscribe("/")
+
+	if err := l.Reload(); err != nil {
+		log.Println("tls reload failed:", err.Error())
+	}
+
+	go l.watch(changes)
+
+	return l
+}
+
+func (l *Loader) watch(changes <-chan common.ChangedEvent) {
+	ticker := time.NewTicker(fileCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-changes:
+			if !l.affected(event.Path) {
+				continue
+			}
+		case <-ticker.C:
+			if !l.filesChanged() {
+				continue
+			}
+		case <-l.stop:
+			l.manager.Unsubscribe(changes)
+			return
+		}
+
+		if err := l.Reload(); err != nil {
+			log.Println("tls reload failed:", err.Error())
+		}
+	}
+}
+
+func (l *Loader) affected(path string) bool {
+	if strings.HasPrefix(path, "/lds/") {
+		return true
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.zkRefs[path]
+}
+
+func (l *Loader) filesChanged() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for file, modTime := range l.files {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *Loader) Close() {
+	close(l.stop)
+}
+
+// Config 供http.Server使用的配置，握手时按SNI选择证书和监听器的TLS设置
+func (l *Loader) Config() *tls.Config {
+	return &tls.Config{GetConfigForClient: l.GetConfigForClient}
+}
+
+// GetConfigForClient 依次按完全匹配、通配符域名(*.example.com)、*查找
+func (l *Loader) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	name := strings.ToLower(hello.ServerName)
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if cert, ok := l.domains[name]; ok {
+		return cert.config, nil
+	}
+
+	if i := strings.Index(name, "."); i >= 0 {
+		if cert, ok := l.domains["*"+name[i:]]; ok {
+			return cert.config, nil
+		}
+	}
+
+	if cert, ok := l.domains["*"]; ok {
+		return cert.config, nil
+	}
+
+	return nil, fmt.Errorf("no certificate for server name %q", hello.ServerName)
+}
+
+// Expiring 在within时间内过期(包括已过期)的证书，按过期时间排序
+func (l *Loader) Expiring(within time.Duration) []Expiry {
+	deadline := time.Now().Add(within)
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	seen := make(map[*loadedCert]bool)
+	var result []Expiry
+
+	for _, cert := range l.domains {
+		if seen[cert] || cert.expiry.After(deadline) {
+			continue
+		}
+		seen[cert] = true
+
+		result = append(result, Expiry{Domains: cert.domains, CertRef: cert.certRef, NotAfter: cert.expiry})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].NotAfter.Before(result[j].NotAfter)
+	})
+
+	return result
+}
+
+// Reload 重新加载全部证书，返回遇到的第一个错误
+func (l *Loader) Reload() error {
+	domains := make(map[string]*loadedCert)
+	zkRefs := make(map[string]bool)
+	files := make(map[string]time.Time)
+
+	l.mu.RLock()
+	previous := l.domains
+	l.mu.RUnlock()
+
+	var firstErr error
+
+	for node, lds := range l.manager.GetAllLds() {
+		for _, listener := range lds.Listeners {
+			if listener == nil || !listener.EnableTLS {
+				continue
+			}
+
+			settings := listener.TLS
+			if err := settings.Validate(); err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("lds %s: %v", node, err)
+				}
+				keepPrevious(domains, previous, listener.Domains)
+				continue
+			}
+
+			for _, ref := range append([]string{settings.ClientCARef}, certRefs(settings)...) {
+				l.trackRef(ref, zkRefs, files)
+			}
+
+			for _, certificate := range settings.Certificates {
+				certDomains := certificate.Domains
+				if len(certDomains) == 0 {
+					certDomains = listener.Domains
+				}
+
+				cert, err := l.load(settings, certificate)
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("lds %s: %v", node, err)
+					}
+					keepPrevious(domains, previous, certDomains)
+					continue
+				}
+				cert.domains = certDomains
+
+				if time.Until(cert.expiry) < ExpiryWarning {
+					log.Printf("tls certificate %s for %s expires at %s\n", cert.certRef, strings.Join(certDomains, ","), cert.expiry.Format(time.RFC3339))
+				}
+
+				for _, domain := range certDomains {
+					domains[strings.ToLower(domain)] = cert
+				}
+			}
+		}
+	}
+
+	l.mu.Lock()
+	l.domains = domains
+	l.zkRefs = zkRefs
+	l.files = files
+	l.mu.Unlock()
+
+	return firstErr
+}
+
+func keepPrevious(domains map[string]*loadedCert, previous map[string]*loadedCert, names []string) {
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if cert, ok := previous[name]; ok {
+			domains[name] = cert
+		}
+	}
+}
+
+func certRefs(settings *envoy.TLSSettings) []string {
+	var refs []string
+	for _, cert := range settings.Certificates {
+		refs = append(refs, cert.CertRef, cert.KeyRef)
+	}
+	return refs
+}
+
+func (l *Loader) trackRef(ref string, zkRefs map[string]bool, files map[string]time.Time) {
+	if ref == "" {
+		return
+	}
+
+	if strings.HasPrefix(ref, filePrefix) {
+		file := strings.TrimPrefix(ref, filePrefix)
+		if info, err := os.Stat(file); err == nil {
+			files[file] = info.ModTime()
+		} else {
+			files[file] = time.Time{}
+		}
+		return
+	}
+
+	zkRefs[strings.TrimPrefix(ref, zkPrefix)] = true
+}
+
+func (l *Loader) load(settings *envoy.TLSSettings, certificate *envoy.TLSCertificate) (*loadedCert, error) {
+	certPEM, err := l.read(certificate.CertRef)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := l.read(certificate.KeyRef)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", certificate.CertRef, err)
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", certificate.CertRef, err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("%s: certificate expired at %s", certificate.CertRef, leaf.NotAfter.Format(time.RFC3339))
+	}
+	pair.Leaf = leaf
+
+	minVersion, _ := settings.GetMinVersion()
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		MinVersion:   minVersion,
+	}
+
+	if settings.ClientAuth != envoy.ClientAuth_None {
+		caPEM, err := l.read(settings.ClientCARef)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("%s: no valid ca certificate", settings.ClientCARef)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if settings.ClientAuth == envoy.ClientAuth_Require {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return &loadedCert{certRef: certificate.CertRef, config: config, expiry: leaf.NotAfter}, nil
+}
+
+// read file:开头时读取文件，否则读取zk节点
+func (l *Loader) read(ref string) ([]byte, error) {
+	if strings.HasPrefix(ref, filePrefix) {
+		return ioutil.ReadFile(strings.TrimPrefix(ref, filePrefix))
+	}
+
+	path := strings.TrimPrefix(ref, zkPrefix)
+	if v, ok := l.manager.Get(path).(string); ok && v != "" {
+		return []byte(v), nil
+	}
+
+	return nil, errors.New(path + " not found")
+}
@@ -0,0 +1,153 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+func newCert(t *testing.T, domain string, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	return string(certPEM), string(keyPEM)
+}
+
+func serverName(t *testing.T, l *Loader, name string) string {
+	config, err := l.GetConfigForClient(&tls.ClientHelloInfo{ServerName: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.Certificates[0].Leaf.Subject.CommonName
+}
+
+func TestLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileCert, fileKey := newCert(t, "*.static.example.com", time.Now().Add(10*24*time.Hour))
+	ioutil.WriteFile(filepath.Join(dir, "static.crt"), []byte(fileCert), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "static.key"), []byte(fileKey), 0600)
+
+	m := &common.Manager{}
+
+	apiCert, apiKey := newCert(t, "api.example.com", time.Now().Add(365*24*time.Hour))
+	m.SetCache("/config/tls/api.crt", apiCert)
+	m.SetCache("/config/tls/api.key", apiKey)
+
+	lds := &envoy.LDS{
+		Name: "api",
+		Listeners: []*envoy.Listener{
+			{
+				Domains:   []string{"api.example.com"},
+				EnableTLS: true,
+				TLS: &envoy.TLSSettings{
+					MinVersion:   "1.3",
+					Certificates: []*envoy.TLSCertificate{{CertRef: "zk:/config/tls/api.crt", KeyRef: "/config/tls/api.key"}},
+				},
+			},
+			{
+				Domains:   []string{"*.static.example.com"},
+				EnableTLS: true,
+				TLS: &envoy.TLSSettings{
+					Certificates: []*envoy.TLSCertificate{{CertRef: "file:" + filepath.Join(dir, "static.crt"), KeyRef: "file:" + filepath.Join(dir, "static.key")}},
+				},
+			},
+		},
+	}
+	m.SetCache("/lds/api", util.StructToJson(lds))
+
+	l := NewLoader(m)
+	defer l.Close()
+
+	config, err := l.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "API.example.com"})
+	if err != nil || config.MinVersion != tls.VersionTLS13 {
+		t.Fatalf("unexpected config %v", err)
+	}
+	if name := serverName(t, l, "img.static.example.com"); name != "*.static.example.com" {
+		t.Fatalf("unexpected certificate %s", name)
+	}
+	if _, err := l.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Fatal("unknown server name should fail")
+	}
+
+	expiring := l.Expiring(ExpiryWarning)
+	if len(expiring) != 1 || expiring[0].Domains[0] != "*.static.example.com" {
+		t.Fatalf("unexpected expiring certificates %+v", expiring)
+	}
+
+	rotatedCert, rotatedKey := newCert(t, "api.example.com", time.Now().Add(5*24*time.Hour))
+	m.SetCache("/config/tls/api.key", rotatedKey)
+	m.SetCache("/config/tls/api.crt", rotatedCert)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(l.Expiring(ExpiryWarning)) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated certificate was not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	m.SetCache("/config/tls/api.crt", "invalid")
+	if err := l.Reload(); err == nil {
+		t.Fatal("invalid certificate should fail")
+	}
+	if name := serverName(t, l, "api.example.com"); name != "api.example.com" {
+		t.Fatal("previous certificate should be kept")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := map[string]*envoy.TLSSettings{
+		"no certificates": {},
+		"bad version":     {MinVersion: "1.4", Certificates: []*envoy.TLSCertificate{{CertRef: "a", KeyRef: "b"}}},
+		"missing key":     {Certificates: []*envoy.TLSCertificate{{CertRef: "a"}}},
+		"missing ca":      {ClientAuth: envoy.ClientAuth_Require, Certificates: []*envoy.TLSCertificate{{CertRef: "a", KeyRef: "b"}}},
+	}
+
+	for name, settings := range cases {
+		if settings.Validate() == nil {
+			t.Errorf("%s should be invalid", name)
+		}
+	}
+}
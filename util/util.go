@@ -16,6 +16,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	http "net/http"
 	"strings"
 
@@ -268,3 +269,35 @@ func AESDecrypt(key string, sEnc string) (string, error) {
 	}
 	return string(plaintext), nil
 }
+
+//获取客户端真实ip，trustedProxies为部署在前面的可信代理层数
+//X-Forwarded-For由客户端可任意伪造，只从右向左跳过可信代理追加的地址；为0时直接使用RemoteAddr
+func GetClientIp(r *http.Request, trustedProxies int) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if trustedProxies <= 0 {
+		return host
+	}
+
+	var forwarded []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(value, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				forwarded = append(forwarded, ip)
+			}
+		}
+	}
+
+	if len(forwarded) == 0 {
+		return host
+	}
+
+	//每层可信代理追加一个地址，倒数第trustedProxies个即为最外层代理看到的客户端
+	if i := len(forwarded) - trustedProxies; i > 0 {
+		return forwarded[i]
+	}
+	return forwarded[0]
+}
@@ -0,0 +1,249 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/plugin"
+	"github.com/mgcicd/cicd-core/util"
+)
+
+// 校验失败的规则名，对应FieldError.Rule
+const (
+	RuleRequired = "required"
+	RuleType     = "type"
+	RulePattern  = "pattern"
+	RuleLength   = "length"
+	RuleEnum     = "enum"
+)
+
+// FieldError 单个参数的校验错误
+type FieldError struct {
+	Name    string `json:"name"`
+	In      string `json:"in"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error 参数校验失败时返回给客户端的400响应体
+type Error struct {
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Errors  []*FieldError `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		messages = append(messages, fe.Name+": "+fe.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// WriteTo 以JSON写出错误
+func (e *Error) WriteTo(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(e.Code)
+	_, _ = w.Write([]byte(util.StructToJson(e)))
+}
+
+var patterns sync.Map
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+// CheckRules 检查规则本身的配置，比如正则是否合法、In和Type是否可识别
+func CheckRules(rules []*envoy.ParamRule) error {
+	for i, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d: name is required", i)
+		}
+
+		switch rule.GetIn() {
+		case envoy.ParamInQuery, envoy.ParamInHeader:
+		default:
+			return fmt.Errorf("rule %s: unsupported in %q", rule.Name, rule.In)
+		}
+
+		switch rule.GetType() {
+		case envoy.ParamString, envoy.ParamInt, envoy.ParamFloat, envoy.ParamBool:
+		default:
+			return fmt.Errorf("rule %s: unsupported type %q", rule.Name, rule.Type)
+		}
+
+		if rule.Pattern != "" {
+			if _, err := compile(rule.Pattern); err != nil {
+				return fmt.Errorf("rule %s: %v", rule.Name, err)
+			}
+		}
+
+		if rule.MaxLength > 0 && rule.MinLength > rule.MaxLength {
+			return fmt.Errorf("rule %s: min length %d is greater than max length %d", rule.Name, rule.MinLength, rule.MaxLength)
+		}
+	}
+
+	return nil
+}
+
+// value 参数出现多次时取最后一个，header与query一致
+func value(r *http.Request, rule *envoy.ParamRule) string {
+	if rule.GetIn() == envoy.ParamInHeader {
+		values := r.Header.Values(rule.Name)
+		if len(values) == 0 {
+			return ""
+		}
+		return values[len(values)-1]
+	}
+
+	return util.GetUrlQueryStringByLastOne(rule.Name, r.URL.Query())
+}
+
+func checkType(t envoy.ParamType, v string) bool {
+	var err error
+	switch t {
+	case envoy.ParamInt:
+		_, err = strconv.ParseInt(v, 10, 64)
+	case envoy.ParamFloat:
+		_, err = strconv.ParseFloat(v, 64)
+	case envoy.ParamBool:
+		_, err = strconv.ParseBool(v)
+	}
+	return err == nil
+}
+
+func checkRule(rule *envoy.ParamRule, v string) *FieldError {
+	fieldError := func(name string, message string) *FieldError {
+		return &FieldError{Name: rule.Name, In: string(rule.GetIn()), Rule: name, Message: message}
+	}
+
+	if v == "" {
+		if rule.Required {
+			return fieldError(RuleRequired, "is required")
+		}
+		return nil
+	}
+
+	if !checkType(rule.GetType(), v) {
+		return fieldError(RuleType, "must be "+string(rule.GetType()))
+	}
+
+	length := utf8.RuneCountInString(v)
+	if length < rule.MinLength || (rule.MaxLength > 0 && length > rule.MaxLength) {
+		if rule.MaxLength > 0 {
+			return fieldError(RuleLength, fmt.Sprintf("length must be between %d and %d", rule.MinLength, rule.MaxLength))
+		}
+		return fieldError(RuleLength, fmt.Sprintf("length must be at least %d", rule.MinLength))
+	}
+
+	if rule.Pattern != "" {
+		re, err := compile(rule.Pattern)
+		if err != nil {
+			log.Printf("validator: rule %s: %v\n", rule.Name, err)
+		} else if !re.MatchString(v) {
+			return fieldError(RulePattern, "must match "+rule.Pattern)
+		}
+	}
+
+	if len(rule.Enum) > 0 {
+		for _, e := range rule.Enum {
+			if e == v {
+				return nil
+			}
+		}
+		return fieldError(RuleEnum, "must be one of "+strings.Join(rule.Enum, ","))
+	}
+
+	return nil
+}
+
+// Validate 按规则校验请求参数，返回所有不通过的参数，全部通过时返回nil
+func Validate(r *http.Request, rules []*envoy.ParamRule) *Error {
+	var errs []*FieldError
+
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		if fe := checkRule(rule, value(r, rule)); fe != nil {
+			errs = append(errs, fe)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return &Error{Code: http.StatusBadRequest, Message: "invalid parameters", Errors: errs}
+}
+
+// ValidateRoute 路由开启VerifyParam时校验请求参数
+func ValidateRoute(r *http.Request, route *envoy.HTTPRoute) *Error {
+	if route == nil || !route.VerifyParam {
+		return nil
+	}
+
+	return Validate(r, route.ParamRules)
+}
+
+// Handler 校验失败时直接返回400，routeFor返回请求命中的路由，比如proxy.Proxy.Lookup
+func Handler(next http.Handler, routeFor func(r *http.Request) *envoy.HTTPRoute) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := ValidateRoute(r, routeFor(r)); err != nil {
+			err.WriteTo(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type validatePlugin struct{}
+
+// OnRequest 返回的*Error实现了plugin.ErrorWriter，经插件链拒绝时与Handler一样返回JSON
+func (p *validatePlugin) OnRequest(ctx *plugin.Context) error {
+	if err := ValidateRoute(ctx.Request, ctx.Route); err != nil {
+		return err
+	}
+	return nil
+}
+
+var ErrNoRules = errors.New("verify param is enabled without rules")
+
+// RegisterPlugin 以name注册参数校验插件，只对开启了VerifyParam的路由生效
+// 规则配置错误时插件创建失败，所在LDS的插件链拒绝所有请求，不会跳过校验放行
+func RegisterPlugin(name string) {
+	plugin.Register(name, plugin.PhaseRequest, func(lds *envoy.LDS, route *envoy.HTTPRoute) (plugin.Plugin, error) {
+		if !route.VerifyParam {
+			return nil, nil
+		}
+		if len(route.ParamRules) == 0 {
+			return nil, ErrNoRules
+		}
+		if err := CheckRules(route.ParamRules); err != nil {
+			return nil, err
+		}
+		return &validatePlugin{}, nil
+	})
+}
@@ -0,0 +1,122 @@
+package validator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/plugin"
+)
+
+var rules = []*envoy.ParamRule{
+	{Name: "userId", Required: true, Type: envoy.ParamInt},
+	{Name: "status", Enum: []string{"open", "closed"}},
+	{Name: "code", Pattern: "^[A-Z]{3}$"},
+	{Name: "X-Client", In: envoy.ParamInHeader, Required: true, MinLength: 2, MaxLength: 8},
+}
+
+func TestValidate(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/order?userId=abc&userId=1001&status=open&code=ABC", nil)
+	req.Header.Set("X-Client", "ios")
+
+	if err := Validate(req, rules); err != nil {
+		t.Fatalf("expected valid request, got %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/order?userId=1001&userId=abc&status=pending&code=abcd", nil)
+	req.Header.Set("X-Client", "a")
+
+	err := Validate(req, rules)
+	if err == nil || err.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %v", err)
+	}
+
+	expected := []string{"userId:" + RuleType, "status:" + RuleEnum, "code:" + RulePattern, "X-Client:" + RuleLength}
+	if len(err.Errors) != len(expected) {
+		t.Fatalf("unexpected errors %v", err)
+	}
+	for i, fe := range err.Errors {
+		if fe.Name+":"+fe.Rule != expected[i] {
+			t.Errorf("expected %s, got %s:%s", expected[i], fe.Name, fe.Rule)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/order", nil)
+	err = Validate(req, rules)
+	if err == nil || len(err.Errors) != 2 || err.Errors[0].Rule != RuleRequired || err.Errors[1].In != "header" {
+		t.Fatalf("expected required errors, got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	route := &envoy.HTTPRoute{VerifyParam: true, ParamRules: rules[:1]}
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(r *http.Request) *envoy.HTTPRoute {
+		return route
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/order", nil))
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"rule":"required"`) {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+
+	route.VerifyParam = false
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/order", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("route without VerifyParam should not be validated, got %d", rec.Code)
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	if err := CheckRules(rules); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := [][]*envoy.ParamRule{
+		{{Name: "a", Pattern: "("}},
+		{{Name: "a", Type: "date"}},
+		{{Name: "a", In: "cookie"}},
+		{{Name: "a", MinLength: 5, MaxLength: 2}},
+		{{Type: envoy.ParamInt}},
+	}
+	for _, r := range invalid {
+		if CheckRules(r) == nil {
+			t.Errorf("expected error for %+v", r[0])
+		}
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	RegisterPlugin("validator-test")
+
+	route := &envoy.HTTPRoute{Prefix: "/order", VerifyParam: true, ParamRules: rules[:1]}
+	lds := &envoy.LDS{
+		Plugins:   []*envoy.Plugins{{PluginName: "validator-test"}},
+		Listeners: []*envoy.Listener{{Routes: []*envoy.HTTPRoute{route}}},
+	}
+
+	run := func() *httptest.ResponseRecorder {
+		chain, _ := plugin.Build(lds)
+		ctx := &plugin.Context{LDS: lds, Route: route, Request: httptest.NewRequest(http.MethodGet, "/order", nil)}
+
+		rec := httptest.NewRecorder()
+		if err := chain.Route(route).OnRequest(ctx); err != nil {
+			plugin.WriteError(rec, err)
+		}
+		return rec
+	}
+
+	rec := run()
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/json; charset=utf-8" || !strings.Contains(rec.Body.String(), `"rule":"required"`) {
+		t.Fatalf("plugin should write the same JSON as Handler, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	route.ParamRules = []*envoy.ParamRule{{Name: "code", Pattern: "["}}
+	if rec := run(); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("invalid rules should reject requests, got %d", rec.Code)
+	}
+}
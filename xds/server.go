@@ -0,0 +1,324 @@
+package xds
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pushOrder 推送顺序遵循ADS的约定：先CDS再EDS，先LDS再RDS
+var pushOrder = []string{resource.ClusterType, resource.EndpointType, resource.ListenerType, resource.RouteType}
+
+// TypeStatus 某个Envoy节点某类资源的下发状态
+type TypeStatus struct {
+	SentVersion  string
+	AckedVersion string
+	// NackVersion/NackMessage 最近一次被拒绝的版本及原因，之后被ACK的版本不会清除
+	NackVersion string
+	NackMessage string
+	UpdatedAt   time.Time
+}
+
+// Server 基于Manager中/lds、/cds配置的ADS服务，节点变更时重新生成快照并按订阅范围增量推送
+type Server struct {
+	manager *common.Manager
+	opts    Options
+
+	mu       sync.RWMutex
+	snapshot snapshot
+	changed  chan struct{}
+	nodes    map[string]map[string]*TypeStatus
+	nonce    uint64
+
+	stop chan struct{}
+}
+
+func NewServer(m *common.Manager, opts Options) *Server {
+	s := &Server{
+		manager:  m,
+		opts:     opts,
+		snapshot: snapshot{},
+		changed:  make(chan struct{}),
+		nodes:    make(map[string]map[string]*TypeStatus),
+		stop:     make(chan struct{}),
+	}
+
+	lds := m.Subscribe("/lds/")
+	cds := m.Subscribe("/cds/")
+
+	s.Reload()
+
+	go s.watch(lds, cds)
+
+	return s
+}
+
+func (s *Server) Register(grpcServer *grpc.Server) {
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(grpcServer, s)
+}
+
+func (s *Server) watch(lds <-chan common.ChangedEvent, cds <-chan common.ChangedEvent) {
+	for {
+		select {
+		case <-lds:
+			s.Reload()
+		case <-cds:
+			s.Reload()
+		case <-s.stop:
+			s.manager.Unsubscribe(lds)
+			s.manager.Unsubscribe(cds)
+			return
+		}
+	}
+}
+
+func (s *Server) Close() {
+	close(s.stop)
+}
+
+// Reload 重新转换全部配置生成快照，转换失败时保留上一份快照
+func (s *Server) Reload() {
+	resources, err := Translate(s.manager.GetAllLds(), s.manager.GetAllCds(), s.opts)
+	if err != nil {
+		log.Printf("xds: translate failed: %v\n", err)
+		return
+	}
+
+	snap, err := newSnapshot(resources)
+	if err != nil {
+		log.Printf("xds: build snapshot failed: %v\n", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot = snap
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) current() (snapshot, <-chan struct{}) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.snapshot, s.changed
+}
+
+// NodeStatus 返回节点各类资源的下发状态，key为TypeUrl
+func (s *Server) NodeStatus(nodeId string) map[string]TypeStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]TypeStatus)
+	for typeUrl, st := range s.nodes[nodeId] {
+		result[typeUrl] = *st
+	}
+
+	return result
+}
+
+func (s *Server) updateStatus(nodeId string, typeUrl string, update func(st *TypeStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	types := s.nodes[nodeId]
+	if types == nil {
+		types = make(map[string]*TypeStatus)
+		s.nodes[nodeId] = types
+	}
+
+	st := types[typeUrl]
+	if st == nil {
+		st = &TypeStatus{}
+		types[typeUrl] = st
+	}
+
+	update(st)
+	st.UpdatedAt = time.Now()
+}
+
+func (s *Server) nextNonce() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nonce++
+	return strconv.FormatUint(s.nonce, 10)
+}
+
+// watchState 一个stream上某类资源的订阅状态
+type watchState struct {
+	names       []string
+	sentVersion string
+	nonce       string
+	pending     bool //需要应答，即使版本没有变化
+}
+
+type streamState struct {
+	nodeId  string
+	watches map[string]*watchState
+}
+
+func (s *Server) StreamAggregatedResources(stream discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	requests := make(chan *discoveryv3.DiscoveryRequest)
+	errs := make(chan error, 1)
+
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			select {
+			case requests <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	st := &streamState{watches: make(map[string]*watchState)}
+
+	for {
+		snap, changed := s.current()
+
+		if err := s.push(stream, st, snap); err != nil {
+			return err
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case err := <-errs:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case req := <-requests:
+			if err := s.handle(st, req); err != nil {
+				return err
+			}
+		case <-changed:
+		case <-s.stop:
+			return status.Error(codes.Unavailable, "server closed")
+		}
+	}
+}
+
+// handle 处理ACK/NACK，过期nonce的请求直接忽略
+// 首次订阅(没有nonce)或订阅的资源名变化时需要应答，比如CDS更新后Envoy会重新请求未变化的EDS，不应答时集群会一直处于warming
+// 不支持的类型只记录日志，不中断整个ADS stream
+func (s *Server) handle(st *streamState, req *discoveryv3.DiscoveryRequest) error {
+	if st.nodeId == "" && req.Node != nil {
+		st.nodeId = req.Node.Id
+	}
+
+	switch req.TypeUrl {
+	case resource.ListenerType, resource.RouteType, resource.ClusterType, resource.EndpointType:
+	default:
+		log.Printf("xds: node %s requested unsupported type %s, ignored\n", st.nodeId, req.TypeUrl)
+		return nil
+	}
+
+	w := st.watches[req.TypeUrl]
+	if w == nil {
+		w = &watchState{}
+		st.watches[req.TypeUrl] = w
+	}
+
+	if req.ResponseNonce != "" && req.ResponseNonce != w.nonce {
+		return nil
+	}
+
+	if req.ResponseNonce != "" {
+		if req.ErrorDetail != nil {
+			log.Printf("xds: node %s rejected %s version %s: %s\n", st.nodeId, req.TypeUrl, w.sentVersion, req.ErrorDetail.Message)
+			s.updateStatus(st.nodeId, req.TypeUrl, func(ts *TypeStatus) {
+				ts.NackVersion = w.sentVersion
+				ts.NackMessage = req.ErrorDetail.Message
+			})
+		} else {
+			s.updateStatus(st.nodeId, req.TypeUrl, func(ts *TypeStatus) {
+				ts.AckedVersion = req.VersionInfo
+			})
+		}
+	}
+
+	if req.ResponseNonce == "" || !sameNames(w.names, req.ResourceNames) {
+		w.pending = true
+	}
+	w.names = req.ResourceNames
+
+	return nil
+}
+
+func sameNames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	set := make(map[string]int, len(a))
+	for _, name := range a {
+		set[name]++
+	}
+	for _, name := range b {
+		if set[name] == 0 {
+			return false
+		}
+		set[name]--
+	}
+
+	return true
+}
+
+// push 订阅范围内的资源版本与上次下发不同或请求需要应答时推送，被NACK的版本不会主动重复推送
+func (s *Server) push(stream discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesServer, st *streamState, snap snapshot) error {
+	for _, typeUrl := range pushOrder {
+		w := st.watches[typeUrl]
+		if w == nil {
+			continue
+		}
+
+		version, resources := snap.subset(typeUrl, w.names)
+		if version == w.sentVersion && !w.pending {
+			continue
+		}
+
+		nonce := s.nextNonce()
+		err := stream.Send(&discoveryv3.DiscoveryResponse{
+			VersionInfo: version,
+			Resources:   resources,
+			TypeUrl:     typeUrl,
+			Nonce:       nonce,
+		})
+		if err != nil {
+			return err
+		}
+
+		w.sentVersion = version
+		w.nonce = nonce
+		w.pending = false
+
+		s.updateStatus(st.nodeId, typeUrl, func(ts *TypeStatus) {
+			ts.SentVersion = version
+		})
+	}
+
+	return nil
+}
+
+func (s *Server) DeltaAggregatedResources(stream discoveryv3.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	return status.Error(codes.Unimplemented, "delta xds is not supported")
+}
@@ -0,0 +1,174 @@
+package xds
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	common "github.com/mgcicd/cicd-core/config/common"
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestStream(t *testing.T, s *Server) discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesClient {
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	s.Register(grpcServer)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	stream, err := discoveryv3.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stream
+}
+
+func setCds(m *common.Manager, ips ...string) {
+	eds := &envoy.EDS{Name: "user"}
+	for _, ip := range ips {
+		eds.Endpoints = append(eds.Endpoints, &envoy.Endpoint{Ip: ip, Port: 80})
+	}
+	m.SetCache("/cds/user", util.StructToJson(eds))
+}
+
+func TestServer_Stream(t *testing.T) {
+	m := &common.Manager{}
+	setCds(m, "10.0.0.1")
+	m.SetCache("/cds/order", util.StructToJson(&envoy.EDS{Name: "order"}))
+
+	s := NewServer(m, Options{})
+	defer s.Close()
+
+	stream := newTestStream(t, s)
+	node := &corev3.Node{Id: "envoy-1"}
+
+	send := func(req *discoveryv3.DiscoveryRequest) {
+		req.Node = node
+		req.TypeUrl = resource.EndpointType
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(&discoveryv3.DiscoveryRequest{ResourceNames: []string{"user"}})
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Resources) != 1 {
+		t.Fatalf("expected 1 resource, got %d", len(resp.Resources))
+	}
+	send(&discoveryv3.DiscoveryRequest{ResourceNames: []string{"user"}, VersionInfo: resp.VersionInfo, ResponseNonce: resp.Nonce})
+	waitStatus(t, s, func(st TypeStatus) bool { return st.AckedVersion == resp.VersionInfo })
+
+	// order不在订阅范围内，不应触发推送
+	m.SetCache("/cds/order", util.StructToJson(&envoy.EDS{Name: "order", Endpoints: []*envoy.Endpoint{{Ip: "10.0.1.1"}}}))
+	setCds(m, "10.0.0.1", "10.0.0.2")
+
+	next, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.VersionInfo == resp.VersionInfo {
+		t.Fatal("version should change after cds update")
+	}
+
+	cla := &endpointv3.ClusterLoadAssignment{}
+	if err := ptypes.UnmarshalAny(next.Resources[0], cla); err != nil {
+		t.Fatal(err)
+	}
+	if cla.ClusterName != "user" || len(cla.Endpoints[0].LbEndpoints) != 2 {
+		t.Fatalf("unexpected assignment %v", cla)
+	}
+
+	send(&discoveryv3.DiscoveryRequest{
+		ResourceNames: []string{"user"},
+		VersionInfo:   resp.VersionInfo,
+		ResponseNonce: next.Nonce,
+		ErrorDetail:   &status.Status{Message: "bad endpoints"},
+	})
+
+	st := waitStatus(t, s, func(st TypeStatus) bool { return st.NackMessage == "bad endpoints" })
+	if st.AckedVersion != resp.VersionInfo || st.NackVersion != next.VersionInfo || st.SentVersion != next.VersionInfo {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestServer_Rerequest(t *testing.T) {
+	m := &common.Manager{}
+	setCds(m, "10.0.0.1")
+
+	s := NewServer(m, Options{})
+	defer s.Close()
+
+	stream := newTestStream(t, s)
+	node := &corev3.Node{Id: "envoy-1"}
+
+	send := func(typeUrl string, req *discoveryv3.DiscoveryRequest) {
+		req.Node = node
+		req.TypeUrl = typeUrl
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(resource.EndpointType, &discoveryv3.DiscoveryRequest{ResourceNames: []string{"user"}})
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(resource.EndpointType, &discoveryv3.DiscoveryRequest{ResourceNames: []string{"user"}, VersionInfo: resp.VersionInfo, ResponseNonce: resp.Nonce})
+	waitStatus(t, s, func(st TypeStatus) bool { return st.AckedVersion == resp.VersionInfo })
+
+	// 不支持的类型不应中断stream
+	send(resource.SecretType, &discoveryv3.DiscoveryRequest{ResourceNames: []string{"cert"}})
+
+	// 版本未变化的重新订阅也要应答
+	send(resource.EndpointType, &discoveryv3.DiscoveryRequest{ResourceNames: []string{"user"}, VersionInfo: resp.VersionInfo})
+	again, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.VersionInfo != resp.VersionInfo || again.Nonce == resp.Nonce || len(again.Resources) != 1 {
+		t.Fatalf("unexpected response %v", again)
+	}
+}
+
+func waitStatus(t *testing.T, s *Server, done func(st TypeStatus) bool) TypeStatus {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		st := s.NodeStatus("envoy-1")[resource.EndpointType]
+		if done(st) {
+			return st
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("node status was not updated")
+	return TypeStatus{}
+}
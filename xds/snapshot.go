@@ -0,0 +1,103 @@
+package xds
+
+import (
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/golang/protobuf/ptypes/any"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+type namedResource struct {
+	hash     string
+	resource *any.Any
+}
+
+// snapshot 某一时刻的全部资源，按类型和名称索引，生成后只读
+type snapshot map[string]map[string]*namedResource
+
+func newSnapshot(resources *Resources) (snapshot, error) {
+	s := snapshot{
+		resource.ListenerType: {},
+		resource.RouteType:    {},
+		resource.ClusterType:  {},
+		resource.EndpointType: {},
+	}
+
+	add := func(typeUrl string, name string, message proto.Message) error {
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		packed, err := anypb.New(message)
+		if err != nil {
+			return err
+		}
+
+		s[typeUrl][name] = &namedResource{
+			hash:     fmt.Sprintf("%x", md5.Sum(data)),
+			resource: packed,
+		}
+		return nil
+	}
+
+	for _, m := range resources.Listeners {
+		if err := add(resource.ListenerType, m.Name, m); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range resources.Routes {
+		if err := add(resource.RouteType, m.Name, m); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range resources.Clusters {
+		if err := add(resource.ClusterType, m.Name, m); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range resources.Endpoints {
+		if err := add(resource.EndpointType, m.ClusterName, m); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// subset 按订阅的资源名取出资源，names为空表示订阅该类型全部资源
+// 版本号与EDS.GetCdsVersion一样取md5，由返回资源的名称和内容决定，订阅范围内没有变化时版本号不变
+func (s snapshot) subset(typeUrl string, names []string) (string, []*any.Any) {
+	all := s[typeUrl]
+
+	if len(names) == 0 {
+		names = make([]string, 0, len(all))
+		for name := range all {
+			names = append(names, name)
+		}
+	} else {
+		names = append([]string(nil), names...)
+	}
+	sort.Strings(names)
+
+	var keys []string
+	var resources []*any.Any
+
+	for _, name := range names {
+		r := all[name]
+		if r == nil {
+			continue
+		}
+		keys = append(keys, name+"="+r.hash)
+		resources = append(resources, r.resource)
+	}
+
+	version := fmt.Sprintf("%x", md5.Sum([]byte(typeUrl+"|"+strings.Join(keys, "*"))))
+
+	return version, resources
+}
@@ -0,0 +1,466 @@
+package xds
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	matcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Options 转换参数，LDS中没有端口信息，所有LDS共用一个监听器和一份路由配置
+type Options struct {
+	ListenerName    string
+	ListenerPort    uint32
+	RouteConfigName string
+	ConnectTimeout  time.Duration
+	WebSocket       bool //监听器是否允许websocket升级，Translate中有WebSocket协议的集群时自动开启
+}
+
+func (o Options) withDefaults() Options {
+	if o.ListenerName == "" {
+		o.ListenerName = "http"
+	}
+	if o.ListenerPort == 0 {
+		o.ListenerPort = 80
+	}
+	if o.RouteConfigName == "" {
+		o.RouteConfigName = "http"
+	}
+	if o.ConnectTimeout == 0 {
+		o.ConnectTimeout = time.Second
+	}
+	return o
+}
+
+// Resources 一次转换得到的Envoy v3资源，按名称排序
+type Resources struct {
+	Listeners []*listenerv3.Listener
+	Routes    []*routev3.RouteConfiguration
+	Clusters  []*clusterv3.Cluster
+	Endpoints []*endpointv3.ClusterLoadAssignment
+}
+
+// Translate 将/lds、/cds节点(key为节点名)转换为Envoy资源
+func Translate(lds map[string]*envoy.LDS, cds map[string]*envoy.EDS, opts Options) (*Resources, error) {
+	opts = opts.withDefaults()
+
+	resources := &Resources{
+		Routes: []*routev3.RouteConfiguration{TranslateRouteConfiguration(opts.RouteConfigName, lds)},
+	}
+
+	names := make([]string, 0, len(cds))
+	for name := range cds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		eds := cds[name]
+		if eds.Name != "" {
+			name = eds.Name
+		}
+
+		if eds.GetProtocol() == envoy.LpWebSocket {
+			opts.WebSocket = true
+		}
+
+		cluster, err := TranslateCluster(name, eds, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		resources.Clusters = append(resources.Clusters, cluster)
+		resources.Endpoints = append(resources.Endpoints, TranslateLoadAssignment(name, eds))
+	}
+
+	listener, err := TranslateListener(opts)
+	if err != nil {
+		return nil, err
+	}
+	resources.Listeners = []*listenerv3.Listener{listener}
+
+	return resources, nil
+}
+
+// TranslateListener HTTP监听器，路由通过ADS以RDS方式下发
+func TranslateListener(opts Options) (*listenerv3.Listener, error) {
+	opts = opts.withDefaults()
+
+	manager := &hcmv3.HttpConnectionManager{
+		StatPrefix: opts.ListenerName,
+		RouteSpecifier: &hcmv3.HttpConnectionManager_Rds{
+			Rds: &hcmv3.Rds{
+				ConfigSource:    adsConfigSource(),
+				RouteConfigName: opts.RouteConfigName,
+			},
+		},
+		HttpFilters: []*hcmv3.HttpFilter{{Name: wellknown.Router}},
+	}
+
+	if opts.WebSocket {
+		manager.UpgradeConfigs = []*hcmv3.HttpConnectionManager_UpgradeConfig{{UpgradeType: "websocket"}}
+	}
+
+	config, err := ptypes.MarshalAny(manager)
+	if err != nil {
+		return nil, err
+	}
+
+	return &listenerv3.Listener{
+		Name: opts.ListenerName,
+		Address: &corev3.Address{
+			Address: &corev3.Address_SocketAddress{
+				SocketAddress: &corev3.SocketAddress{
+					Address:       "0.0.0.0",
+					PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: opts.ListenerPort},
+				},
+			},
+		},
+		FilterChains: []*listenerv3.FilterChain{{
+			Filters: []*listenerv3.Filter{{
+				Name:       wellknown.HTTPConnectionManager,
+				ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: config},
+			}},
+		}},
+	}, nil
+}
+
+func adsConfigSource() *corev3.ConfigSource {
+	return &corev3.ConfigSource{
+		ResourceApiVersion:    corev3.ApiVersion_V3,
+		ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+	}
+}
+
+type routeCandidate struct {
+	matchType envoy.RouteMatchType
+	route     *envoy.HTTPRoute
+	enableTLS bool
+	mirror    *envoy.MirrorPolicy
+}
+
+// TranslateRouteConfiguration Envoy要求域名在虚拟主机间唯一，因此按域名合并所有LDS中的路由，
+// 每个域名一个虚拟主机，路由顺序与proxy包一致：Path完全匹配、Prefix按长度从长到短、Regex
+func TranslateRouteConfiguration(name string, lds map[string]*envoy.LDS) *routev3.RouteConfiguration {
+	nodes := make([]string, 0, len(lds))
+	for node := range lds {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	domains := make(map[string][]*routeCandidate)
+
+	for _, node := range nodes {
+		doc := lds[node]
+		for _, listener := range doc.Listeners {
+			if listener == nil {
+				continue
+			}
+			for _, route := range listener.Routes {
+				if route == nil {
+					continue
+				}
+				for _, domain := range listener.Domains {
+					domain = strings.ToLower(domain)
+					domains[domain] = append(domains[domain], &routeCandidate{
+						matchType: doc.RouteMatchType,
+						route:     route,
+						enableTLS: listener.EnableTLS,
+						mirror:    doc.GetMirror(route),
+					})
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(domains))
+	for domain := range domains {
+		names = append(names, domain)
+	}
+	sort.Strings(names)
+
+	config := &routev3.RouteConfiguration{Name: name}
+
+	for _, domain := range names {
+		candidates := domains[domain]
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return routeRank(candidates[i]) < routeRank(candidates[j])
+		})
+
+		host := &routev3.VirtualHost{
+			Name:    domain,
+			Domains: []string{domain},
+		}
+
+		for _, candidate := range candidates {
+			if candidate.enableTLS {
+				host.RequireTls = routev3.VirtualHost_ALL
+			}
+			route := TranslateRoute(candidate.matchType, candidate.route)
+			if candidate.mirror != nil {
+				route.GetRoute().RequestMirrorPolicies = translateMirror(candidate.mirror)
+			}
+			host.Routes = append(host.Routes, route)
+		}
+
+		config.VirtualHosts = append(config.VirtualHosts, host)
+	}
+
+	return config
+}
+
+// routeRank 越小越先匹配
+func routeRank(candidate *routeCandidate) int {
+	switch candidate.matchType {
+	case envoy.Path:
+		return 0
+	case envoy.Prefix:
+		return 1<<20 - len(candidate.route.Prefix)
+	default:
+		return 1 << 21
+	}
+}
+
+func TranslateRoute(matchType envoy.RouteMatchType, route *envoy.HTTPRoute) *routev3.Route {
+	match := &routev3.RouteMatch{}
+	action := &routev3.RouteAction{
+		ClusterSpecifier: &routev3.RouteAction_Cluster{Cluster: route.ClusterName},
+		Timeout:          ptypes.DurationProto(route.GetTimeOut()),
+	}
+
+	switch matchType {
+	case envoy.Path:
+		match.PathSpecifier = &routev3.RouteMatch_Path{Path: route.Prefix}
+		action.PrefixRewrite = route.PrefixRewrite
+	case envoy.Regex:
+		regex := &matcherv3.RegexMatcher{
+			EngineType: &matcherv3.RegexMatcher_GoogleRe2{GoogleRe2: &matcherv3.RegexMatcher_GoogleRE2{}},
+			Regex:      route.Prefix,
+		}
+		match.PathSpecifier = &routev3.RouteMatch_SafeRegex{SafeRegex: regex}
+		if route.PrefixRewrite != "" {
+			action.RegexRewrite = &matcherv3.RegexMatchAndSubstitute{
+				Pattern:      regex,
+				Substitution: route.PrefixRewrite,
+			}
+		}
+	default:
+		match.PathSpecifier = &routev3.RouteMatch_Prefix{Prefix: route.Prefix}
+		action.PrefixRewrite = route.PrefixRewrite
+	}
+
+	if route.HostRewrite {
+		action.HostRewriteSpecifier = &routev3.RouteAction_AutoHostRewrite{AutoHostRewrite: &wrappers.BoolValue{Value: true}}
+	}
+
+	if idle := route.GetIdleTimeOut(); idle > 0 {
+		action.IdleTimeout = ptypes.DurationProto(idle)
+	}
+
+	if route.Retry != nil {
+		action.RetryPolicy = translateRetry(route)
+	}
+
+	return &routev3.Route{
+		Name:   route.Prefix,
+		Match:  match,
+		Action: &routev3.Route_Route{Route: action},
+	}
+}
+
+// translateRetry Envoy的重试预算配置在集群的熔断阈值上，不随路由下发
+func translateRetry(route *envoy.HTTPRoute) *routev3.RetryPolicy {
+	policy := &routev3.RetryPolicy{
+		RetryOn:    route.Retry.RetryOnString(),
+		NumRetries: &wrappers.UInt32Value{Value: route.Retry.GetNumRetries()},
+		RetryBackOff: &routev3.RetryPolicy_RetryBackOff{
+			BaseInterval: ptypes.DurationProto(route.Retry.GetBaseInterval()),
+			MaxInterval:  ptypes.DurationProto(route.Retry.GetMaxInterval()),
+		},
+	}
+
+	if perTry := route.GetPerTryTimeOut(); perTry > 0 {
+		policy.PerTryTimeout = ptypes.DurationProto(perTry)
+	}
+
+	return policy
+}
+
+// translateMirror Envoy镜像请求的Host会加上-shadow后缀，不支持自定义标记header
+func translateMirror(mirror *envoy.MirrorPolicy) []*routev3.RouteAction_RequestMirrorPolicy {
+	return []*routev3.RouteAction_RequestMirrorPolicy{{
+		Cluster: mirror.ClusterName,
+		RuntimeFraction: &corev3.RuntimeFractionalPercent{
+			DefaultValue: &typev3.FractionalPercent{
+				Numerator:   uint32(mirror.Percentage * 10000),
+				Denominator: typev3.FractionalPercent_MILLION,
+			},
+		},
+	}}
+}
+
+// TranslateCluster 熔断、异常点检测、健康检查只在EDS中对应开关启用时下发
+// HTTP2、gRPC集群使用HTTP2连接上游，HTTPS、TLS集群使用TLS连接上游
+func TranslateCluster(name string, eds *envoy.EDS, opts Options) (*clusterv3.Cluster, error) {
+	opts = opts.withDefaults()
+
+	cluster := &clusterv3.Cluster{
+		Name:                 name,
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+		EdsClusterConfig: &clusterv3.Cluster_EdsClusterConfig{
+			EdsConfig: adsConfigSource(),
+		},
+		ConnectTimeout: ptypes.DurationProto(opts.ConnectTimeout),
+	}
+
+	if eds.EnableCircuitbreaker == int(util.True) && len(eds.CircuitBreaker) > 0 {
+		cluster.CircuitBreakers = &clusterv3.CircuitBreakers{}
+		for _, cb := range eds.CircuitBreaker {
+			if cb != nil {
+				cluster.CircuitBreakers.Thresholds = append(cluster.CircuitBreakers.Thresholds, translateThresholds(cb))
+			}
+		}
+	}
+
+	if eds.EnableOutlierDetection == util.True {
+		od := eds.OutlierDetection
+		cluster.OutlierDetection = &clusterv3.OutlierDetection{
+			Consecutive_5Xx:          &wrappers.UInt32Value{Value: od.GetConsecutive5xx()},
+			Interval:                 ptypes.DurationProto(od.GetInterval()),
+			BaseEjectionTime:         ptypes.DurationProto(od.GetBaseEjectionTime()),
+			MaxEjectionPercent:       &wrappers.UInt32Value{Value: od.GetMaxEjectionPercent()},
+			EnforcingConsecutive_5Xx: &wrappers.UInt32Value{Value: od.GetEnforcingConsecutive5xx()},
+		}
+	}
+
+	if eds.EnableHealthCheck == util.True && eds.HealthCheck.HttpHealthCheck.Path != "" {
+		hc := eds.HealthCheck
+		cluster.HealthChecks = []*corev3.HealthCheck{{
+			Timeout:            ptypes.DurationProto(hc.GetTimeout()),
+			Interval:           ptypes.DurationProto(hc.GetInterval()),
+			HealthyThreshold:   &wrappers.UInt32Value{Value: hc.GetHealthyThreshold()},
+			UnhealthyThreshold: &wrappers.UInt32Value{Value: hc.GetUnhealthyThreshold()},
+			HealthChecker: &corev3.HealthCheck_HttpHealthCheck_{
+				HttpHealthCheck: &corev3.HealthCheck_HttpHealthCheck{Path: hc.HttpHealthCheck.Path},
+			},
+		}}
+	}
+
+	protocol := eds.GetProtocol()
+
+	if protocol.IsHTTP2() {
+		cluster.Http2ProtocolOptions = &corev3.Http2ProtocolOptions{}
+	}
+
+	if protocol.IsTLS() {
+		tlsContext, err := anypb.New(&tlsv3.UpstreamTlsContext{Sni: name})
+		if err != nil {
+			return nil, err
+		}
+
+		cluster.TransportSocket = &corev3.TransportSocket{
+			Name:       wellknown.TransportSocketTls,
+			ConfigType: &corev3.TransportSocket_TypedConfig{TypedConfig: tlsContext},
+		}
+	}
+
+	return cluster, nil
+}
+
+func translateThresholds(cb *envoy.CircuitBreaker) *clusterv3.CircuitBreakers_Thresholds {
+	thresholds := &clusterv3.CircuitBreakers_Thresholds{
+		Priority:       corev3.RoutingPriority(cb.Priority),
+		TrackRemaining: cb.TrackRemaining,
+	}
+
+	if cb.MaxConnections > 0 {
+		thresholds.MaxConnections = &wrappers.UInt32Value{Value: cb.MaxConnections}
+	}
+	if cb.MaxPendingRequests > 0 {
+		thresholds.MaxPendingRequests = &wrappers.UInt32Value{Value: cb.MaxPendingRequests}
+	}
+	if cb.MaxRequests > 0 {
+		thresholds.MaxRequests = &wrappers.UInt32Value{Value: cb.MaxRequests}
+	}
+	if cb.MaxRetries > 0 {
+		thresholds.MaxRetries = &wrappers.UInt32Value{Value: cb.MaxRetries}
+	}
+	if cb.MaxConnectionPools > 0 {
+		thresholds.MaxConnectionPools = &wrappers.UInt32Value{Value: cb.MaxConnectionPools}
+	}
+
+	return thresholds
+}
+
+// TranslateLoadAssignment 禁用的endpoint不下发，版本号写入envoy.lb元数据以便按版本做子集路由
+func TranslateLoadAssignment(name string, eds *envoy.EDS) *endpointv3.ClusterLoadAssignment {
+	endpoints := make([]*envoy.Endpoint, 0, len(eds.Endpoints))
+	for _, ep := range eds.Endpoints {
+		if ep != nil && ep.Status != util.No {
+			endpoints = append(endpoints, ep)
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Ip != endpoints[j].Ip {
+			return endpoints[i].Ip < endpoints[j].Ip
+		}
+		return endpoints[i].Port < endpoints[j].Port
+	})
+
+	locality := &endpointv3.LocalityLbEndpoints{}
+
+	for _, ep := range endpoints {
+		lbEndpoint := &endpointv3.LbEndpoint{
+			HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+				Endpoint: &endpointv3.Endpoint{
+					Address: &corev3.Address{
+						Address: &corev3.Address_SocketAddress{
+							SocketAddress: &corev3.SocketAddress{
+								Address:       ep.Ip,
+								PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(ep.Port)},
+							},
+						},
+					},
+					Hostname: ep.Name,
+				},
+			},
+		}
+
+		if ep.Weight > 0 {
+			lbEndpoint.LoadBalancingWeight = &wrappers.UInt32Value{Value: uint32(ep.Weight)}
+		}
+
+		if ep.Version != "" {
+			lbEndpoint.Metadata = &corev3.Metadata{
+				FilterMetadata: map[string]*structpb.Struct{
+					"envoy.lb": {Fields: map[string]*structpb.Value{"version": structpb.NewStringValue(ep.Version)}},
+				},
+			}
+		}
+
+		locality.LbEndpoints = append(locality.LbEndpoints, lbEndpoint)
+	}
+
+	return &endpointv3.ClusterLoadAssignment{
+		ClusterName: name,
+		Endpoints:   []*endpointv3.LocalityLbEndpoints{locality},
+	}
+}
@@ -0,0 +1,135 @@
+package xds
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/mgcicd/cicd-core/config/envoy"
+	"github.com/mgcicd/cicd-core/util"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+type translateInput struct {
+	Lds map[string]*envoy.LDS
+	Cds map[string]*envoy.EDS
+}
+
+func loadInput(t *testing.T) translateInput {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "input.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var input translateInput
+	if err := util.ByteToStruct(data, &input); err != nil {
+		t.Fatal(err)
+	}
+
+	return input
+}
+
+// marshalGolden protojson的输出格式不稳定，统一用encoding/json重新缩进
+func marshalGolden(t *testing.T, messages []proto.Message) []byte {
+	items := make([]json.RawMessage, 0, len(messages))
+	for _, message := range messages {
+		data, err := protojson.Marshal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, data)
+	}
+
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(data, '\n')
+}
+
+func assertGolden(t *testing.T, name string, messages []proto.Message) {
+	actual := marshalGolden(t, messages)
+	path := filepath.Join("testdata", name+".golden.json")
+
+	if *update {
+		if err := ioutil.WriteFile(path, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("%s does not match golden file, run go test ./xds -update to regenerate\n%s", name, actual)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	input := loadInput(t)
+
+	resources, err := Translate(input.Lds, input.Cds, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listeners, routes, clusters, endpoints []proto.Message
+	for _, m := range resources.Listeners {
+		listeners = append(listeners, m)
+	}
+	for _, m := range resources.Routes {
+		routes = append(routes, m)
+	}
+	for _, m := range resources.Clusters {
+		clusters = append(clusters, m)
+	}
+	for _, m := range resources.Endpoints {
+		endpoints = append(endpoints, m)
+	}
+
+	assertGolden(t, "listeners", listeners)
+	assertGolden(t, "routes", routes)
+	assertGolden(t, "clusters", clusters)
+	assertGolden(t, "endpoints", endpoints)
+}
+
+func TestTranslate_Validate(t *testing.T) {
+	input := loadInput(t)
+
+	resources, err := Translate(input.Lds, input.Cds, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range resources.Listeners {
+		if err := m.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, m := range resources.Routes {
+		if err := m.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, m := range resources.Clusters {
+		if err := m.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, m := range resources.Endpoints {
+		if err := m.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+}